@@ -2,4 +2,7 @@ module github.com/magisterquis/mqtxtar
 
 go 1.23
 
-require golang.org/x/tools v0.24.0
+require (
+	golang.org/x/text v0.17.0
+	golang.org/x/tools v0.24.0
+)
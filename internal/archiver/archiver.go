@@ -6,18 +6,23 @@ package archiver
  * mqtxtar's underlying archiver
  * By J. Stuart McMurray
  * Created 20240812
- * Last Modified 20240819
+ * Last Modified 20261018
  */
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
+	"time"
 )
 
 const (
@@ -25,6 +30,21 @@ const (
 	CreatePerms = 0600
 )
 
+// gzipMagic starts every gzipped file.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// VCSNames are the names of version control directories and files excluded
+// if Archiver.ExcludeVCS is set.
+var VCSNames = []string{
+	".bzr", ".bzrignore", ".bzrtags",
+	".cvsignore", "CVS",
+	".git", ".gitattributes", ".gitignore", ".gitmodules",
+	".hg", ".hgignore", ".hgtags",
+	".svn",
+	"_darcs",
+	"RCS", "SCCS",
+}
+
 // Archiver is what actually does the mqtxtar things.
 type Archiver struct {
 	Comment  string /* Archive comment. */
@@ -33,13 +53,60 @@ type Archiver struct {
 
 	Paths       []string /* Paths to add/extract, i.e. flag.Args(). */
 	UnsafePaths bool     /* Don't strip leading /'s. */
+	NullPaths   bool     /* Path lists are NUL-separated. */
+
+	LiteralPaths []string /* Paths matched exactly, not as globs. */
 
 	Verbose bool /* Verbose messages. */
 
 	ExcludeGlobs []string         /* Blacklist of globs. */
 	ExcludeREs   []*regexp.Regexp /* Blacklist of Regexen. */
+	IncludeGlobs []string         /* Whitelist of globs. */
+	IncludeREs   []*regexp.Regexp /* Whitelist of Regexen. */
+	Anchored     bool             /* Globs match whole paths. */
+	IgnoreCase   bool             /* Globs and paths ignore case. */
+
+	GitRev string /* Git commit from which to create the archive. */
+
+	MaxSize int64     /* Don't add files larger than this, if set. */
+	MinSize int64     /* Don't add files smaller than this. */
+	Newer   time.Time /* Only add files modified after this, if set. */
+	Older   time.Time /* Only add files modified before this, if set. */
+
+	SkipBinary bool /* Don't add files which look binary. */
+
+	UseIgnoreFiles bool /* Honor .mqtxtarignore files. */
+	UseGitignore   bool /* Honor .gitignore files. */
+	ExcludeVCS     bool /* Exclude version control files. */
+
+	StripComponents   int           /* Leading components to not extract. */
+	Flatten           bool          /* Extract without directories. */
+	FlattenCollisions FlattenPolicy /* For colliding flattened names. */
+	ToStdout          bool          /* Extract to the listing output. */
+
+	Transforms []*Transform /* Name rewrites, applied in order. */
+	Prefix     string       /* Prepended to names when creating. */
+
+	StdinName string   /* Name for a file read from stdin, if set. */
+	AddTexts  []string /* name=content files to add. */
+	AddCmds   []string /* name=command files to add. */
+
+	Renames     map[string]string /* Archive name -> host path. */
+	hostRenames map[string]string /* Host path -> archive name. */
+
+	MaxTotalSize int64 /* Largest uncompressed archive to read. */
+	MaxEntrySize int64 /* Largest file in an archive to read. */
+	MaxEntries   int   /* Most files in an archive to read. */
+
+	CheckCollisions  bool /* Warn about case-insensitive collisions. */
+	StrictCollisions bool /* Don't extract colliding names. */
+
+	SkipBadNames bool /* Skip, don't fail on, names txtar can't handle. */
 
-	fs fs.FS /* For testing. */
+	fs          fs.FS       /* For testing. */
+	archiveInfo fs.FileInfo /* The archive itself, to not archive. */
+	gitDir      string      /* For testing. */
+	stdin       io.Reader   /* For testing. */
 }
 
 // New returns a new Archiver, ready for use.
@@ -81,9 +148,44 @@ func New(
 //}
 
 // isExcluded returns true if any of a's exclude globs or regexes matches fpath.
+// Unless a.Anchored is set, globs without a slash are matched against each of
+// fpath's components, like tar.
 func (a Archiver) isExcluded(fpath string) (bool, error) {
-	for _, g := range a.ExcludeGlobs {
-		ok, err := path.Match(g, fpath)
+	if a.ExcludeVCS && isVCSPath(fpath) {
+		return true, nil
+	}
+	return a.matchesAny(a.ExcludeGlobs, a.ExcludeREs, fpath)
+}
+
+// isVCSPath returns true if any of the components of the host path fpath is
+// in VCSNames.
+func isVCSPath(fpath string) bool {
+	for _, c := range strings.Split(filepath.ToSlash(fpath), "/") {
+		if slices.Contains(VCSNames, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// isIncluded returns true if we have no include globs or regexes or if any of
+// them matches fpath.  Globs are matched as in isExcluded.
+func (a Archiver) isIncluded(fpath string) (bool, error) {
+	if 0 == len(a.IncludeGlobs) && 0 == len(a.IncludeREs) {
+		return true, nil
+	}
+	return a.matchesAny(a.IncludeGlobs, a.IncludeREs, fpath)
+}
+
+// matchesAny returns true if any of the globs or regexes matches fpath.
+func (a Archiver) matchesAny(
+	globs []string,
+	res []*regexp.Regexp,
+	fpath string,
+) (bool, error) {
+	fpath = filepath.ToSlash(fpath)
+	for _, g := range globs {
+		ok, err := a.globMatches(g, fpath)
 		if nil != err {
 			return false, err
 		}
@@ -91,7 +193,7 @@ func (a Archiver) isExcluded(fpath string) (bool, error) {
 			return true, nil
 		}
 	}
-	for _, re := range a.ExcludeREs {
+	for _, re := range res {
 		if re.MatchString(fpath) {
 			return true, nil
 		}
@@ -99,52 +201,278 @@ func (a Archiver) isExcluded(fpath string) (bool, error) {
 	return false, nil
 }
 
-// AddPathsFromFile adds paths from the file fn.  Each line in the file should
-// be one path.  Duplicates aren't added.
+// globMatches returns true if the glob g matches the /-path p.  If a.Anchored
+// is set or g has a slash, g must match all of p, with ** matching any number
+// of components.  Otherwise, g may match any of p's components.  If
+// a.IgnoreCase is set, case is ignored.
+func (a Archiver) globMatches(g, p string) (bool, error) {
+	if a.IgnoreCase {
+		g, p = strings.ToLower(g), strings.ToLower(p)
+	}
+	/* Anchored globs must match everything. */
+	if a.Anchored || strings.Contains(g, "/") {
+		return matchGlob(g, p)
+	}
+	/* Unanchored globs can match any component. */
+	for _, c := range strings.Split(p, "/") {
+		switch c {
+		case "", ".", "..": /* Not really a name. */
+			continue
+		}
+		if ok, err := path.Match(g, c); nil != err || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// AddPathsFromFile adds paths from the file fn, or standard input if fn is -.
+// Each line in the file should be one path.  Blank lines and lines starting
+// with a # are ignored.  If a.NullPaths is set, paths are instead separated by
+// NULs, as from find -print0, and are used as-is.  Duplicates aren't added.
 func (a *Archiver) AddPathsFromFile(fn string) error {
+	/* Open the file, or use stdin. */
+	var r io.Reader = os.Stdin
+	if "-" == fn && nil != a.stdin {
+		r = a.stdin
+	} else if "-" != fn {
+		f, err := os.Open(fn)
+		if nil != err {
+			return fmt.Errorf("opening: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	/* Prep for path-by-path reading. */
+	var (
+		ps      []string
+		scanner = bufio.NewScanner(r)
+	)
+	if a.NullPaths {
+		scanner.Split(scanNulls)
+	}
+	/* Get each path from the file. */
+	for scanner.Scan() {
+		/* Get the next path, ignoring blanks and comments. */
+		l := scanner.Text()
+		if !a.NullPaths {
+			l = strings.TrimSpace(l)
+			if strings.HasPrefix(l, "#") {
+				continue
+			}
+		}
+		if "" == l {
+			continue
+		}
+		ps = append(ps, l)
+	}
+	if err := scanner.Err(); nil != err {
+		return fmt.Errorf("reading paths: %s", err)
+	}
+
+	a.Paths = appendNew(a.Paths, ps)
+	return nil
+}
+
+// AddPathsFromArchive adds the names of the files in the archive fn to
+// a.LiteralPaths, as host paths.  The archive is read as in ListOrExtract and
+// is subject to a's limits, but is gunzipped if it starts with gzip's magic
+// number, regardless of a.WithGzip.  Names are safened unless a.UnsafePaths
+// is set.  Duplicates aren't added.  As an archive with no files would select
+// everything, it's an error.
+func (a *Archiver) AddPathsFromArchive(fn string) error {
+	sa := *a
+	sa.Filename = fn
+	var err error
+	if sa.WithGzip, err = a.isGzipped(fn); nil != err {
+		return err
+	}
+	ar, err := sa.readArchive()
+	if nil != err {
+		return err
+	}
+	if 0 == len(ar.Files) {
+		return errors.New("no files in archive")
+	}
+	ps := make([]string, len(ar.Files))
+	for i, f := range ar.Files {
+		ps[i] = filepath.FromSlash(a.maybeSafenPath(f.Name))
+	}
+	a.LiteralPaths = appendNew(a.LiteralPaths, ps)
+	return nil
+}
+
+// isGzipped returns true if the file fn starts with gzip's magic number.
+func (a Archiver) isGzipped(fn string) (bool, error) {
+	var (
+		f   fs.File
+		err error
+	)
+	if nil == a.fs {
+		f, err = os.Open(fn)
+	} else {
+		f, err = a.fs.Open(fn)
+	}
+	if nil != err {
+		return false, fmt.Errorf("opening %s: %w", fn, err)
+	}
+	defer f.Close()
+	magic := make([]byte, len(gzipMagic))
+	if _, err := io.ReadFull(f, magic); errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return false, nil /* Too small to be gzipped. */
+	} else if nil != err {
+		return false, fmt.Errorf("reading %s: %w", fn, err)
+	}
+	return bytes.Equal(magic, gzipMagic), nil
+}
+
+// appendNew appends the paths in ps to dst, skipping duplicates.
+func appendNew(dst, ps []string) []string {
 	/* Mapify, for not adding dupes. */
 	m := make(map[string]struct{})
-	for _, p := range a.Paths {
+	for _, p := range dst {
+		m[p] = struct{}{}
+	}
+	for _, p := range ps {
+		/* Don't add dupes. */
+		if _, ok := m[p]; ok {
+			continue
+		}
+		/* Looks like we've a new one. */
 		m[p] = struct{}{}
+		dst = append(dst, p)
 	}
+	return dst
+}
 
-	/* Open the file and prep for line-by-line reading. */
+// scanNulls is a bufio.SplitFunc which splits on NULs.
+func scanNulls(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, 0); -1 != i {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && 0 != len(data) {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// AddExcludesFromFile adds exclude globs from the file fn.  Each line in the
+// file should be one glob.  Blank lines and lines starting with a # are
+// ignored.
+func (a *Archiver) AddExcludesFromFile(fn string) error {
 	f, err := os.Open(fn)
 	if nil != err {
 		return fmt.Errorf("opening: %w", err)
 	}
 	defer f.Close()
-	scanner := bufio.NewScanner(f)
-	/* Add each line from the file. */
+	gs, err := readPatterns(f)
+	if nil != err {
+		return err
+	}
+	a.ExcludeGlobs = append(a.ExcludeGlobs, gs...)
+	return nil
+}
+
+// AddRenamesFromFile adds renames to a.Renames from the file fn.  Each line in
+// the file should be of the form
+//
+//	archive/name => host/path
+//
+// Blank lines and lines starting with a # are ignored, as is whitespace around
+// the names.  A name may only appear once on each side.
+func (a *Archiver) AddRenamesFromFile(fn string) error {
+	f, err := os.Open(fn)
+	if nil != err {
+		return fmt.Errorf("opening: %w", err)
+	}
+	defer f.Close()
+	ls, err := readPatterns(f)
+	if nil != err {
+		return err
+	}
+
+	/* Note what we have, to catch duplicates. */
+	if nil == a.Renames {
+		a.Renames = make(map[string]string)
+	}
+	hps := make(map[string]bool)
+	for _, hp := range a.Renames {
+		hps[filepath.Clean(hp)] = true
+	}
+
+	/* Add each rename. */
+	for _, l := range ls {
+		an, hp, ok := strings.Cut(l, "=>")
+		an, hp = strings.TrimSpace(an), strings.TrimSpace(hp)
+		if !ok || "" == an || "" == hp {
+			return fmt.Errorf("invalid rename %q", l)
+		}
+		an, hp = path.Clean(an), filepath.Clean(hp)
+		if _, ok := a.Renames[an]; ok {
+			return fmt.Errorf("duplicate archive name %s", an)
+		}
+		if hps[hp] {
+			return fmt.Errorf("duplicate host path %s", hp)
+		}
+		a.Renames[an] = hp
+		hps[hp] = true
+	}
+
+	return nil
+}
+
+// readPatterns reads patterns from r, one per line, ignoring blank lines and
+// lines starting with a #.  Leading and trailing whitespace is removed.
+func readPatterns(r io.Reader) ([]string, error) {
+	var (
+		ps      []string
+		scanner = bufio.NewScanner(r)
+	)
 	for scanner.Scan() {
-		/* Get the next line, ignoring blanks. */
 		l := strings.TrimSpace(scanner.Text())
-		if "" == l {
+		if "" == l || strings.HasPrefix(l, "#") {
 			continue
 		}
-		/* Don't add dupes. */
-		if _, ok := m[l]; ok {
-			continue
-		}
-		/* Looks like we've a new one. */
-		m[l] = struct{}{}
-		a.Paths = append(a.Paths, l)
+		ps = append(ps, l)
 	}
 	if err := scanner.Err(); nil != err {
-		return fmt.Errorf("reading lines: %s", err)
+		return nil, fmt.Errorf("reading lines: %w", err)
 	}
+	return ps, nil
+}
 
-	return nil
+// readFile reads the file at host path p, from a.fs if we have it.
+func (a Archiver) readFile(p string) ([]byte, error) {
+	if nil != a.fs {
+		return fs.ReadFile(a.fs, p)
+	}
+	return os.ReadFile(p)
 }
 
-// ToHostPath turns the txtar path p into an OS path, possibly safening it.
+// ToHostPath turns the txtar path p into an OS path, possibly safening it and
+// applying a.Transforms.
 func (a *Archiver) ToHostPath(p string) string {
-	return filepath.FromSlash(a.maybeSafenPath(p))
+	return filepath.FromSlash(a.transform(a.maybeSafenPath(p)))
 }
 
-// FromHostPath turns the host path p into a txtar path, possibly safening it.
+// FromHostPath turns the host path p into a txtar path, possibly safening it
+// and applying a.Transforms.
 func (a *Archiver) FromHostPath(p string) string {
-	return a.maybeSafenPath(filepath.ToSlash(p))
+	return a.transform(a.maybeSafenPath(filepath.ToSlash(p)))
+}
+
+// transform applies a.Transforms to the /-path p.  As a transform might make
+// a safe path unsafe, the transformed path is safened again.
+func (a *Archiver) transform(p string) string {
+	if 0 == len(a.Transforms) {
+		return p
+	}
+	for _, t := range a.Transforms {
+		p = t.Apply(p)
+	}
+	return a.maybeSafenPath(p)
 }
 
 // maybeSafenPath safens the /-path p if a.UnsafePaths isn't set.
@@ -5,18 +5,26 @@ package archiver
  * Tests for archiver.go
  * By J. Stuart McMurray
  * Created 20240812
- * Last Modified 20240819
+ * Last Modified 20261018
  */
 
 import (
+	"bytes"
+	"compress/gzip"
 	"embed"
 	"encoding/json"
+	"errors"
+	"io"
 	"io/fs"
+	"maps"
 	"os"
 	"path"
 	"path/filepath"
 	"slices"
+	"strings"
 	"testing"
+
+	"golang.org/x/tools/txtar"
 )
 
 //go:embed testdata
@@ -82,6 +90,14 @@ func TestArchiverAddPathsFromFile(t *testing.T) {
 			`,
 			want: []string{"foo", "bar", "baaz", "tridge"},
 		},
+		"comments": testC{
+			haveFile: `# Comment
+			foo
+			  # Indented comment
+			./#bar
+			`,
+			want: []string{"foo", "./#bar"},
+		},
 		"nothing": testC{},
 	}
 	for name, c := range cs {
@@ -204,3 +220,391 @@ func TestArchiverToFromHostPath(t *testing.T) {
 		}
 	})
 }
+
+func TestArchiverIsExcluded(t *testing.T) {
+	cs := []struct {
+		glob         string
+		path         string
+		wantAnchored bool
+		wantFloating bool
+	}{
+		{"*.o", "x.o", true, true},
+		{"*.o", "dir/x.o", false, true},
+		{"*.o", "a/b/c/x.o", false, true},
+		{"*.o", "x.o/y", false, true},
+		{"*.o", "x.c", false, false},
+		{"dir", "dir/x.o", false, true},
+		{"dir", "a/dir", false, true},
+		{"dir", "adir/x", false, false},
+		{".*", "./x", false, false},
+		{".*", "../x", false, false},
+		{".*", "a/.git/x", false, true},
+		{"*/x.o", "dir/x.o", true, true},
+		{"*/x.o", "a/dir/x.o", false, false},
+		{"**/x.o", "a/dir/x.o", true, true},
+		{"dir/**", "dir/a/x.o", true, true},
+		{"dir/**", "a/dir/x.o", false, false},
+		{"a/**/*.o", "a/b/c/x.o", true, true},
+	}
+	for _, c := range cs {
+		for anchored, want := range map[bool]bool{
+			true:  c.wantAnchored,
+			false: c.wantFloating,
+		} {
+			a := Archiver{
+				ExcludeGlobs: []string{c.glob},
+				Anchored:     anchored,
+			}
+			got, err := a.isExcluded(filepath.FromSlash(c.path))
+			if nil != err {
+				t.Errorf(
+					"Error matching %s against %s: %s",
+					c.path,
+					c.glob,
+					err,
+				)
+			} else if got != want {
+				t.Errorf(
+					"Incorrect match of %s against %s "+
+						"(anchored: %t): %t",
+					c.path,
+					c.glob,
+					anchored,
+					got,
+				)
+			}
+		}
+	}
+}
+
+func TestArchiverAddPathsFromFile_Null(t *testing.T) {
+	a := Archiver{Paths: []string{"foo"}, NullPaths: true}
+	fn := filepath.Join(t.TempDir(), "paths")
+	if err := os.WriteFile(
+		fn,
+		[]byte("foo\x00 bar\x00\x00new\nline\x00# not comment\x00last"),
+		0600,
+	); nil != err {
+		t.Fatalf("Error writing paths file %s: %s", fn, err)
+	}
+	if err := a.AddPathsFromFile(fn); nil != err {
+		t.Fatalf("Error adding paths: %s", err)
+	}
+	want := []string{"foo", " bar", "new\nline", "# not comment", "last"}
+	if !slices.Equal(a.Paths, want) {
+		t.Fatalf("Paths incorrect:\n got: %q\nwant: %q", a.Paths, want)
+	}
+}
+
+func TestArchiverAddPathsFromFile_Stdin(t *testing.T) {
+	a := Archiver{stdin: strings.NewReader("foo\n# bar\ntridge\n")}
+	if err := a.AddPathsFromFile("-"); nil != err {
+		t.Fatalf("Error adding paths: %s", err)
+	}
+	want := []string{"foo", "tridge"}
+	if !slices.Equal(a.Paths, want) {
+		t.Fatalf("Paths incorrect:\n got: %q\nwant: %q", a.Paths, want)
+	}
+}
+
+func TestArchiverAddPathsFromArchive(t *testing.T) {
+	errAny := errors.New("any error")
+	ar := txtar.Format(&txtar.Archive{
+		Comment: []byte("Comment\n"),
+		Files: []txtar.File{
+			{Name: "foo"},
+			{Name: "/abs/bar"},
+			{Name: "../up/tridge"},
+			{Name: "d/foo[1].txt"},
+			{Name: "foo"},
+		},
+	})
+	var zb bytes.Buffer
+	zw := gzip.NewWriter(&zb)
+	if _, err := zw.Write(ar); nil != err {
+		t.Fatalf("Error gzipping archive: %s", err)
+	}
+	if err := zw.Close(); nil != err {
+		t.Fatalf("Error finishing gzipping archive: %s", err)
+	}
+	safe := []string{
+		"foo",
+		filepath.FromSlash("abs/bar"),
+		filepath.FromSlash("up/tridge"),
+		filepath.FromSlash("d/foo[1].txt"),
+	}
+	for name, c := range map[string]struct {
+		b       []byte
+		a       Archiver
+		want    []string
+		wantErr error
+	}{
+		"plain": {
+			b:    ar,
+			want: safe,
+		},
+		"gzipped": {
+			b:    zb.Bytes(),
+			a:    Archiver{WithGzip: true},
+			want: safe,
+		},
+		"gzipped_without_z": {
+			b:    zb.Bytes(),
+			want: safe,
+		},
+		"plain_with_z": {
+			b:    ar,
+			a:    Archiver{WithGzip: true},
+			want: safe,
+		},
+		"gzipped_total_size": {
+			b:       zb.Bytes(),
+			a:       Archiver{MaxTotalSize: 10},
+			wantErr: ErrLimitExceeded,
+		},
+		"tiny": {
+			b:       []byte("x"),
+			wantErr: errAny,
+		},
+		"unsafe": {
+			b: ar,
+			a: Archiver{UnsafePaths: true},
+			want: []string{
+				"foo",
+				filepath.FromSlash("/abs/bar"),
+				filepath.FromSlash("../up/tridge"),
+				filepath.FromSlash("d/foo[1].txt"),
+			},
+		},
+		"total_size": {
+			b:       ar,
+			a:       Archiver{MaxTotalSize: 10},
+			wantErr: ErrLimitExceeded,
+		},
+		"entries": {
+			b:       zb.Bytes(),
+			a:       Archiver{WithGzip: true, MaxEntries: 4},
+			wantErr: ErrLimitExceeded,
+		},
+		"no_files": {
+			b:       []byte("Just a comment\n"),
+			wantErr: errAny,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			fn := filepath.Join(t.TempDir(), "a.txtar")
+			if err := os.WriteFile(fn, c.b, 0600); nil != err {
+				t.Fatalf("Error writing archive: %s", err)
+			}
+			a := c.a
+			a.Paths = []string{"foo"}
+			a.LiteralPaths = []string{"foo"}
+			err := a.AddPathsFromArchive(fn)
+			if nil != c.wantErr {
+				if nil == err || errAny != c.wantErr &&
+					!errors.Is(err, c.wantErr) {
+					t.Fatalf("Incorrect error: %v", err)
+				}
+				return
+			} else if nil != err {
+				t.Fatalf("Error adding paths: %s", err)
+			}
+			if !slices.Equal(a.LiteralPaths, c.want) {
+				t.Fatalf(
+					"LiteralPaths incorrect:\n"+
+						" got: %q\n"+
+						"want: %q",
+					a.LiteralPaths,
+					c.want,
+				)
+			}
+			if want := []string{"foo"}; !slices.Equal(a.Paths, want) {
+				t.Fatalf("Paths changed: %q", a.Paths)
+			}
+		})
+	}
+}
+
+func TestArchiverCreate_SelectFromDifferentCompression(t *testing.T) {
+	/* Files to archive, and a plain archive to select from. */
+	td := t.TempDir()
+	for fn, content := range map[string]string{
+		"a":         "a\n",
+		"b":         "b\n",
+		"sel.txtar": "-- a --\n",
+	} {
+		if err := os.WriteFile(
+			filepath.Join(td, fn),
+			[]byte(content),
+			0600,
+		); nil != err {
+			t.Fatalf("Error writing %s: %s", fn, err)
+		}
+	}
+
+	/* Create a gzipped archive with only the selected file. */
+	a := Archiver{
+		Filename: filepath.Join(t.TempDir(), "out.txtar.gz"),
+		WithGzip: true,
+		fs:       os.DirFS(td),
+	}
+	if err := a.AddPathsFromArchive("sel.txtar"); nil != err {
+		t.Fatalf("Error adding paths: %s", err)
+	}
+	if err := a.Create(); nil != err {
+		t.Fatalf("Create failed: %s", err)
+	}
+	f, err := os.Open(a.Filename)
+	if nil != err {
+		t.Fatalf("Error opening created archive: %s", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if nil != err {
+		t.Fatalf("Error initializing gunzipper: %s", err)
+	}
+	b, err := io.ReadAll(zr)
+	if nil != err {
+		t.Fatalf("Error reading created archive: %s", err)
+	}
+	if got, want := string(b), "-- a --\na\n"; got != want {
+		t.Fatalf("Incorrect archive:\n got: %q\nwant: %q", got, want)
+	}
+}
+
+func TestArchiverAddExcludesFromFile(t *testing.T) {
+	a := Archiver{ExcludeGlobs: []string{"*.o"}}
+	fn := filepath.Join(t.TempDir(), "excludes")
+	if err := os.WriteFile(fn, []byte(`# Comment
+	*.tmp
+
+	  build/**
+# Another comment
+.git
+`), 0600); nil != err {
+		t.Fatalf("Error writing excludes file %s: %s", fn, err)
+	}
+	if err := a.AddExcludesFromFile(fn); nil != err {
+		t.Fatalf("Error adding excludes: %s", err)
+	}
+	want := []string{"*.o", "*.tmp", "build/**", ".git"}
+	if !slices.Equal(a.ExcludeGlobs, want) {
+		t.Fatalf(
+			"ExcludeGlobs incorrect:\n got: %s\nwant: %s",
+			a.ExcludeGlobs,
+			want,
+		)
+	}
+}
+
+func TestArchiverAddRenamesFromFile(t *testing.T) {
+	a := Archiver{Renames: map[string]string{"a": "b"}}
+	fn := filepath.Join(t.TempDir(), "renames")
+	if err := os.WriteFile(fn, []byte(`# Comment
+old_pkg/a.go => new_pkg/a.go
+
+	./x//y =>z/../w
+# Another comment
+spaced name => other name  
+`), 0600); nil != err {
+		t.Fatalf("Error writing renames file %s: %s", fn, err)
+	}
+	if err := a.AddRenamesFromFile(fn); nil != err {
+		t.Fatalf("Error adding renames: %s", err)
+	}
+	want := map[string]string{
+		"a":            "b",
+		"old_pkg/a.go": "new_pkg/a.go",
+		"x/y":          "w",
+		"spaced name":  "other name",
+	}
+	if !maps.Equal(a.Renames, want) {
+		t.Fatalf(
+			"Renames incorrect:\n got: %q\nwant: %q",
+			a.Renames,
+			want,
+		)
+	}
+
+	/* Invalid files. */
+	for _, l := range []string{
+		"no arrow",
+		"=> no/name",
+		"no/path =>",
+		"a => c",
+		"c => ./b",
+	} {
+		a := Archiver{Renames: map[string]string{"a": "b"}}
+		if err := os.WriteFile(fn, []byte(l+"\n"), 0600); nil != err {
+			t.Fatalf("Error writing renames file %s: %s", fn, err)
+		}
+		if err := a.AddRenamesFromFile(fn); nil == err {
+			t.Errorf("No error adding rename %q", l)
+		}
+	}
+}
+
+func TestIsVCSPath(t *testing.T) {
+	for have, want := range map[string]bool{
+		".git":           true,
+		".git/HEAD":      true,
+		"a/.hg/store":    true,
+		"a/.gitignore":   true,
+		"a/CVS":          true,
+		"a/b.go":         false,
+		"a/.github/x":    false,
+		"git/x":          false,
+		"a/.svnrc":       false,
+		"./a/.svn/x":     true,
+		"/abs/_darcs/x":  true,
+		"/abs/darcs/x":   false,
+		".gitattributes": true,
+	} {
+		if got := isVCSPath(filepath.FromSlash(have)); got != want {
+			t.Errorf("Incorrect result for %s: %t", have, got)
+		}
+	}
+}
+
+func TestArchiverIsExcluded_IgnoreCase(t *testing.T) {
+	for _, c := range []struct {
+		glob string
+		path string
+		want bool
+	}{
+		{"*.md", "README.MD", true},
+		{"*.md", "docs/Guide.Md", true},
+		{"DOCS", "docs/x", true},
+		{"Docs/*.TXT", "dOcS/a.txt", true},
+		{"**/[A-C].go", "x/b.GO", true},
+		{"*.md", "README.txt", false},
+	} {
+		for ignoreCase, want := range map[bool]bool{
+			true:  c.want,
+			false: false,
+		} {
+			a := Archiver{
+				ExcludeGlobs: []string{c.glob},
+				IgnoreCase:   ignoreCase,
+			}
+			got, err := a.isExcluded(filepath.FromSlash(c.path))
+			if nil != err {
+				t.Errorf(
+					"Error matching %s against %s: %s",
+					c.path,
+					c.glob,
+					err,
+				)
+			} else if got != want {
+				t.Errorf(
+					"Incorrect match of %s against %s "+
+						"(ignore case: %t): %t",
+					c.path,
+					c.glob,
+					ignoreCase,
+					got,
+				)
+			}
+		}
+	}
+}
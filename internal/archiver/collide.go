@@ -0,0 +1,91 @@
+package archiver
+
+/*
+ * collide.go
+ * Find names which collide on case-insensitive filesystems
+ * By J. Stuart McMurray
+ * Created 20261018
+ * Last Modified 20261018
+ */
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// ErrNameCollision is returned when names in an archive would collide on
+// extraction and a.StrictCollisions is set.
+var ErrNameCollision = errors.New("colliding names")
+
+// foldName returns n case-folded and Unicode-normalized, for comparing names
+// as a case-insensitive, normalizing filesystem would.
+func foldName(n string) string {
+	return cases.Fold().String(norm.NFC.String(n))
+}
+
+// nameCollisions returns groups of distinct names which are the same when
+// case-folded and Unicode-normalized, in the order in which they're first
+// seen.
+func nameCollisions(names []string) [][]string {
+	var (
+		keys   []string
+		groups = make(map[string][]string)
+	)
+	for _, n := range names {
+		k := foldName(n)
+		g, ok := groups[k]
+		if !ok {
+			keys = append(keys, k)
+		}
+		if slices.Contains(g, n) {
+			continue
+		}
+		groups[k] = append(g, n)
+	}
+	var cs [][]string
+	for _, k := range keys {
+		if 1 < len(groups[k]) {
+			cs = append(cs, groups[k])
+		}
+	}
+	return cs
+}
+
+// checkCollisions warns about colliding names if a.CheckCollisions or
+// a.StrictCollisions is set.  If fatal is true and a.StrictCollisions is set,
+// the collisions are returned as an error instead.
+func (a Archiver) checkCollisions(names []string, fatal bool) error {
+	if !a.CheckCollisions && !a.StrictCollisions {
+		return nil
+	}
+	cs := nameCollisions(names)
+	if 0 == len(cs) {
+		return nil
+	}
+	/* Work out what collides with what. */
+	ss := make([]string, len(cs))
+	for i, c := range cs {
+		qs := make([]string, len(c))
+		for j, n := range c {
+			qs[j] = fmt.Sprintf("%q", n)
+		}
+		ss[i] = strings.Join(qs, ", ")
+	}
+	if fatal && a.StrictCollisions {
+		return fmt.Errorf(
+			"%w: %s",
+			ErrNameCollision,
+			strings.Join(ss, "; "),
+		)
+	}
+	for _, s := range ss {
+		fmt.Fprintf(os.Stderr, "Warning: colliding names: %s\n", s)
+	}
+	return nil
+}
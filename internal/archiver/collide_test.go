@@ -0,0 +1,104 @@
+package archiver
+
+/*
+ * collide_test.go
+ * Tests for collide.go
+ * By J. Stuart McMurray
+ * Created 20261018
+ * Last Modified 20261018
+ */
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"golang.org/x/tools/txtar"
+)
+
+func TestNameCollisions(t *testing.T) {
+	cs := map[string]struct {
+		have []string
+		want [][]string
+	}{
+		"none": {
+			have: []string{"a", "b", "c/a"},
+		},
+		"case": {
+			have: []string{"README", "a", "readme", "ReadMe"},
+			want: [][]string{{"README", "readme", "ReadMe"}},
+		},
+		"directory_case": {
+			have: []string{"Dir/a", "dir/a", "dir/b"},
+			want: [][]string{{"Dir/a", "dir/a"}},
+		},
+		"normalization": {
+			have: []string{"caf\u00e9", "cafe\u0301"},
+			want: [][]string{{"caf\u00e9", "cafe\u0301"}},
+		},
+		"normalization_and_case": {
+			have: []string{"CAFE\u0301", "caf\u00e9"},
+			want: [][]string{{"CAFE\u0301", "caf\u00e9"}},
+		},
+		"duplicates": {
+			have: []string{"a", "a", "b"},
+		},
+		"multiple": {
+			have: []string{"a", "B", "A", "b", "c"},
+			want: [][]string{{"a", "A"}, {"B", "b"}},
+		},
+		"nothing": {},
+	}
+	for name, c := range cs {
+		t.Run(name, func(t *testing.T) {
+			got := nameCollisions(c.have)
+			if !slices.EqualFunc(got, c.want, slices.Equal) {
+				t.Fatalf(
+					"Incorrect collisions:\n"+
+						"have: %q\n"+
+						" got: %q\n"+
+						"want: %q",
+					c.have,
+					got,
+					c.want,
+				)
+			}
+		})
+	}
+}
+
+func TestArchiverListExtract_StrictCollisions(t *testing.T) {
+	/* Archive with colliding names. */
+	td := t.TempDir()
+	fn := filepath.Join(td, "a.txtar")
+	if err := os.WriteFile(fn, txtar.Format(&txtar.Archive{
+		Files: []txtar.File{
+			{Name: "README", Data: []byte("upper\n")},
+			{Name: "readme", Data: []byte("lower\n")},
+		},
+	}), 0600); nil != err {
+		t.Fatalf("Error writing archive: %s", err)
+	}
+
+	/* Listing should work. */
+	a := Archiver{Filename: fn, StrictCollisions: true}
+	if err := a.ListOrExtract(io.Discard, "", false); nil != err {
+		t.Fatalf("Listing failed: %s", err)
+	}
+
+	/* Extracting shouldn't, and nothing should be extracted. */
+	xd := filepath.Join(td, "x")
+	if err := a.ListOrExtract(
+		io.Discard,
+		xd,
+		true,
+	); !errors.Is(err, ErrNameCollision) {
+		t.Fatalf("Incorrect error: %v", err)
+	}
+	if _, err := os.Stat(xd); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Extraction directory created (err: %v)", err)
+	}
+}
@@ -5,31 +5,91 @@ package archiver
  * Create a new archive
  * By J. Stuart McMurray
  * Created 20240812
- * Last Modified 20240819
+ * Last Modified 20261018
  */
 
 import (
+	"bytes"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"golang.org/x/tools/txtar"
 )
 
+const (
+	// BinarySniffLen is the number of bytes at the start of a file
+	// examined to see if it's binary, if Archiver.SkipBinary is set.
+	BinarySniffLen = 8000
+	// BinaryInvalidUTF8Ratio is the inverse of the fraction of sniffed
+	// bytes which may be invalid UTF-8 before a file is considered binary.
+	BinaryInvalidUTF8Ratio = 10
+)
+
+// ErrBadName is returned when a file's name can't be represented in a txtar
+// archive.
+var ErrBadName = errors.New("name unrepresentable in txtar")
+
 // Create creates an archive.
 func (a Archiver) Create() error {
 	/* Archive around which this is one big wrapper. */
 	ta := &txtar.Archive{Comment: []byte(a.Comment)}
 
-	/* Add files to the archive, as we get them. */
-	for _, path := range a.Paths {
-		if err := a.addToArchive(ta, path); nil != err {
-			return fmt.Errorf("adding %q: %w", path, err)
+	/* Note the archive file itself, if it's already there, so we don't
+	add it to itself. */
+	a.archiveInfo = a.archiveFileInfo()
+
+	/* Work out which files get renamed. */
+	a.hostRenames = make(map[string]string, len(a.Renames))
+	for an, hp := range a.Renames {
+		a.hostRenames[filepath.Clean(hp)] = an
+	}
+
+	/* Add files to the archive, as we get them, either from git or from
+	the filesystem. */
+	if "" != a.GitRev {
+		id, err := a.addFromGit(ta)
+		if nil != err {
+			return fmt.Errorf("adding from git: %w", err)
 		}
+		if 0 != len(ta.Comment) {
+			ta.Comment = append(ta.Comment, '\n')
+		}
+		ta.Comment = append(ta.Comment, "commit "+id...)
+	} else {
+		for _, path := range slices.Concat(a.Paths, a.LiteralPaths) {
+			if err := a.addToArchive(ta, path); nil != err {
+				return fmt.Errorf("adding %q: %w", path, err)
+			}
+		}
+	}
+
+	/* Add files which don't come from disk. */
+	if err := a.addExtras(ta); nil != err {
+		return err
+	}
+
+	/* Make sure all the names will survive being txtar'd. */
+	if err := a.checkNames(ta); nil != err {
+		return err
+	}
+
+	/* Warn about names which won't extract nicely everywhere. */
+	names := make([]string, len(ta.Files))
+	for i, f := range ta.Files {
+		names[i] = f.Name
+	}
+	if err := a.checkCollisions(names, false); nil != err {
+		return err
 	}
 
 	/* Work out how to write this thing. */
@@ -66,8 +126,94 @@ func (a Archiver) Create() error {
 	return nil
 }
 
+// checkNames makes sure every file in ta has a name which can be represented
+// in a txtar archive.  If a.SkipBadNames is set, files with bad names are
+// removed from ta with a warning.  Otherwise, an error listing all of the bad
+// names is returned.
+func (a Archiver) checkNames(ta *txtar.Archive) error {
+	var bads []string
+	ta.Files = slices.DeleteFunc(ta.Files, func(f txtar.File) bool {
+		prob := txtarNameProblem(f.Name)
+		if "" == prob {
+			return false
+		}
+		if a.SkipBadNames {
+			fmt.Fprintf(
+				os.Stderr,
+				"Warning: skipping %q: %s\n",
+				f.Name,
+				prob,
+			)
+			return true
+		}
+		bads = append(bads, fmt.Sprintf("%q (%s)", f.Name, prob))
+		return false
+	})
+	if 0 != len(bads) {
+		return fmt.Errorf(
+			"%w: %s",
+			ErrBadName,
+			strings.Join(bads, ", "),
+		)
+	}
+	return nil
+}
+
+// txtarNameProblem returns why n can't be used as a name in a txtar archive,
+// or the empty string if it can.
+func txtarNameProblem(n string) string {
+	switch {
+	case "" == n:
+		return "empty name"
+	case strings.Contains(n, "\n"):
+		return "contains a newline"
+	case strings.TrimSpace(n) != n:
+		return "leading or trailing whitespace"
+	}
+	/* Catch anything else txtar can't handle. */
+	ar := txtar.Parse(txtar.Format(&txtar.Archive{
+		Files: []txtar.File{{Name: n}},
+	}))
+	if 1 != len(ar.Files) || n != ar.Files[0].Name {
+		return "not preserved by txtar"
+	}
+	return ""
+}
+
+// archiveFileInfo returns the FileInfo for the file to which the archive will
+// be written, or nil if there isn't a regular file there yet.  This is
+// compared against files found while walking, by device and inode and not by
+// name, to avoid archiving the archive.
+func (a Archiver) archiveFileInfo() fs.FileInfo {
+	var (
+		fi  fs.FileInfo
+		err error
+	)
+	if "" == a.Filename { /* Stdout might be redirected to a file. */
+		fi, err = os.Stdout.Stat()
+	} else {
+		fi, err = os.Stat(a.Filename)
+	}
+	if nil != err || !fi.Mode().IsRegular() {
+		return nil
+	}
+	return fi
+}
+
 // addToArchive adds the files under path to ta.
 func (a Archiver) addToArchive(ta *txtar.Archive, path string) error {
+	/* Work out which ignore files we'll honor. */
+	var igs []*ignorer
+	if a.UseIgnoreFiles {
+		igs = append(igs, newIgnorer(a, IgnoreFileName, false))
+	}
+	if a.UseGitignore {
+		ig := newIgnorer(a, GitignoreFileName, true)
+		if err := ig.loadParents(path); nil != err {
+			return err
+		}
+		igs = append(igs, ig)
+	}
 	wdf := func(
 		path string,
 		d fs.DirEntry,
@@ -89,35 +235,78 @@ func (a Archiver) addToArchive(ta *txtar.Archive, path string) error {
 		if nil != err {
 			return err
 		}
+		/* Skip things in ignore files, and note new ignore files. */
+		for _, ig := range igs {
+			if ign, err := ig.isIgnored(
+				path,
+				d.IsDir(),
+			); nil != err {
+				return err
+			} else if ign && d.IsDir() {
+				return fs.SkipDir
+			} else if ign {
+				return nil
+			}
+		}
+		if d.IsDir() {
+			for _, ig := range igs {
+				if err := ig.load(path); nil != err {
+					return err
+				}
+			}
+		}
 		/* Don't really care about non-regular files. */
 		if !d.Type().IsRegular() {
 			return nil
 		}
-		/* Add this file, removing any previous ones with the same
-		name first. */
-		var b []byte
-		if nil != a.fs { /* Slurp file. */
-			b, err = fs.ReadFile(a.fs, path)
-		} else {
-			b, err = os.ReadFile(path)
+		/* If we're only adding some files, make sure this is one. */
+		if incl, err := a.isIncluded(path); nil != err {
+			return fmt.Errorf(
+				"checking if %s is included: %w",
+				path,
+				err,
+			)
+		} else if !incl {
+			return nil
 		}
+		/* Skip files which are too big, small, old, or new. */
+		if why, err := a.filterReason(d); nil != err {
+			return fmt.Errorf("getting info for %s: %w", path, err)
+		} else if "" != why {
+			if a.Verbose {
+				fmt.Fprintf(
+					os.Stderr,
+					"%s: %s; not added\n",
+					path,
+					why,
+				)
+			}
+			return nil
+		}
+		/* Don't add the archive to itself. */
+		if nil != a.archiveInfo {
+			if fi, err := d.Info(); nil == err &&
+				os.SameFile(fi, a.archiveInfo) {
+				if a.Verbose {
+					fmt.Fprintf(
+						os.Stderr,
+						"%s: file is the archive; "+
+							"not added\n",
+						path,
+					)
+				}
+				return nil
+			}
+		}
+		/* Add this file. */
+		b, err := a.readFile(path) /* Slurp file. */
 		if nil != err {
 			return fmt.Errorf("reading %s: %w", path, err)
 		}
-		path = a.FromHostPath(path)   /* txtarify path. */
-		ta.Files = slices.DeleteFunc( /* Dedupe. */
-			ta.Files,
-			func(f txtar.File) bool {
-				return f.Name == path
-			},
-		)
-		ta.Files = append(ta.Files, txtar.File{ /* Add. */
-			Name: path,
-			Data: b,
-		})
-		if a.Verbose { /* Log. */
-			fmt.Fprintf(os.Stderr, "%s\n", path)
+		if a.skipBinary(path, b) {
+			return nil
 		}
+		a.addFile(ta, path, b)
 		return nil
 	}
 	if nil != a.fs {
@@ -126,3 +315,217 @@ func (a Archiver) addToArchive(ta *txtar.Archive, path string) error {
 		return filepath.WalkDir(path, wdf)
 	}
 }
+
+// filterReason returns why the file described by d should be skipped due to
+// a's size and modification time filters, or the empty string if it
+// shouldn't be.
+func (a Archiver) filterReason(d fs.DirEntry) (string, error) {
+	/* Don't bother getting info if we don't need it. */
+	if 0 == a.MaxSize && 0 == a.MinSize &&
+		a.Newer.IsZero() && a.Older.IsZero() {
+		return "", nil
+	}
+	fi, err := d.Info()
+	if nil != err {
+		return "", err
+	}
+	switch {
+	case 0 != a.MaxSize && fi.Size() > a.MaxSize:
+		return fmt.Sprintf("larger than %d bytes", a.MaxSize), nil
+	case fi.Size() < a.MinSize:
+		return fmt.Sprintf("smaller than %d bytes", a.MinSize), nil
+	case !a.Newer.IsZero() && !fi.ModTime().After(a.Newer):
+		return fmt.Sprintf(
+			"not modified after %s",
+			a.Newer.Format(time.RFC3339),
+		), nil
+	case !a.Older.IsZero() && !fi.ModTime().Before(a.Older):
+		return fmt.Sprintf(
+			"not modified before %s",
+			a.Older.Format(time.RFC3339),
+		), nil
+	}
+	return "", nil
+}
+
+// skipBinary returns true if a.SkipBinary is set and b, the contents of the
+// file at hpath, looks binary.
+func (a Archiver) skipBinary(hpath string, b []byte) bool {
+	if !a.SkipBinary || !isBinary(b) {
+		return false
+	}
+	if a.Verbose {
+		fmt.Fprintf(os.Stderr, "%s: binary file; not added\n", hpath)
+	}
+	return true
+}
+
+// isBinary guesses whether b is binary by looking at its first
+// BinarySniffLen bytes.  It's binary if there's a NUL or if more than
+// 1/BinaryInvalidUTF8Ratio of it isn't valid UTF-8.
+func isBinary(b []byte) bool {
+	if BinarySniffLen < len(b) {
+		b = b[:BinarySniffLen]
+	}
+	if -1 != bytes.IndexByte(b, 0) {
+		return true
+	}
+	var nBad int
+	for i := 0; i < len(b); {
+		r, size := utf8.DecodeRune(b[i:])
+		if utf8.RuneError == r && 1 == size {
+			/* Don't count a rune cut off by sniffing. */
+			if !utf8.FullRune(b[i:]) {
+				break
+			}
+			nBad++
+		}
+		i += size
+	}
+	return nBad*BinaryInvalidUTF8Ratio > len(b)
+}
+
+// ParseTimeOrFile parses s as a time for Archiver.Newer or Archiver.Older.  If
+// s names a file, the file's modification time is returned.  Otherwise s
+// should be an RFC3339 timestamp or a local date or date and time in the form
+// YYYY-MM-DD[ HH:MM[:SS]].
+func ParseTimeOrFile(s string) (time.Time, error) {
+	/* Reference file. */
+	if fi, err := os.Stat(s); nil == err {
+		return fi.ModTime(), nil
+	}
+	/* Timestamp. */
+	if t, err := time.Parse(time.RFC3339, s); nil == err {
+		return t, nil
+	}
+	for _, layout := range []string{
+		time.DateOnly,
+		time.DateTime,
+		"2006-01-02 15:04",
+		"2006-01-02T15:04:05",
+		"2006-01-02T15:04",
+	} {
+		if t, err := time.ParseInLocation(
+			layout,
+			s,
+			time.Local,
+		); nil == err {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("not a file or known time format")
+}
+
+// addExtras adds the files named with a.StdinName, a.AddTexts, and a.AddCmds
+// to ta.
+func (a Archiver) addExtras(ta *txtar.Archive) error {
+	/* File from stdin. */
+	if "" != a.StdinName {
+		var r io.Reader = os.Stdin
+		if nil != a.stdin {
+			r = a.stdin
+		}
+		b, err := io.ReadAll(r)
+		if nil != err {
+			return fmt.Errorf("reading standard input: %w", err)
+		}
+		a.addNamed(ta, a.StdinName, b)
+	}
+
+	/* Literal text. */
+	for _, t := range a.AddTexts {
+		name, content, ok := strings.Cut(t, "=")
+		if !ok {
+			return fmt.Errorf("no = in %q", t)
+		}
+		if "" == name {
+			return fmt.Errorf("no name in %q", t)
+		}
+		a.addNamed(ta, name, []byte(content))
+	}
+
+	/* Command output. */
+	for _, c := range a.AddCmds {
+		name, b, err := runNamedCommand(c)
+		if nil != err {
+			return fmt.Errorf("adding output of %q: %w", c, err)
+		}
+		a.addNamed(ta, name, b)
+	}
+
+	return nil
+}
+
+// runNamedCommand runs the command in c, which is of the form
+// name=command args..., and returns the name and the command's output.  The
+// command is split into arguments with splitCommand, which understands quotes
+// and backslashes, and run without a shell.
+func runNamedCommand(c string) (string, []byte, error) {
+	name, cmd, ok := strings.Cut(c, "=")
+	if !ok {
+		return "", nil, errors.New("no =")
+	}
+	if "" == name {
+		return "", nil, errors.New("no name")
+	}
+	args, err := splitCommand(cmd)
+	if nil != err {
+		return "", nil, err
+	}
+	if 0 == len(args) {
+		return "", nil, errors.New("no command")
+	}
+	b, err := runCommand(exec.Command(args[0], args[1:]...))
+	if nil != err {
+		return "", nil, err
+	}
+	return name, b, nil
+}
+
+// addFile adds a file with the host path hpath and contents b to ta, removing
+// any previous ones with the same name first.
+func (a Archiver) addFile(ta *txtar.Archive, hpath string, b []byte) {
+	a.addEntry(ta, a.archiveName(hpath), b)
+}
+
+// addNamed adds a file with contents b to ta, with the archive name name,
+// after prepending a.Prefix and safening if a.UnsafePaths isn't set.  Any
+// previous files with the same name are removed first.
+func (a Archiver) addNamed(ta *txtar.Archive, name string, b []byte) {
+	a.addEntry(ta, a.maybeSafenPath(a.Prefix+name), b)
+}
+
+// addEntry adds a file named name with contents b to ta, removing any
+// previous ones with the same name first.
+func (a Archiver) addEntry(ta *txtar.Archive, name string, b []byte) {
+	ta.Files = slices.DeleteFunc( /* Dedupe. */
+		ta.Files,
+		func(f txtar.File) bool {
+			return f.Name == name
+		},
+	)
+	ta.Files = append(ta.Files, txtar.File{ /* Add. */
+		Name: name,
+		Data: b,
+	})
+	if a.Verbose { /* Log. */
+		fmt.Fprintf(os.Stderr, "%s\n", name)
+	}
+}
+
+// archiveName returns the name in the archive for the file with host path
+// hpath.  If hpath is in a.Renames, it gets its name from there.  Like git
+// archive, a.Prefix is then prepended as-is, so should usually end in a slash.
+// The name is safened unless a.UnsafePaths is set.
+func (a Archiver) archiveName(hpath string) string {
+	name, ok := a.hostRenames[filepath.Clean(hpath)]
+	if ok {
+		name = a.maybeSafenPath(name)
+	} else {
+		name = a.FromHostPath(hpath)
+	}
+	if "" == a.Prefix {
+		return name
+	}
+	return a.maybeSafenPath(a.Prefix + name)
+}
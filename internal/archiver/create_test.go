@@ -5,18 +5,27 @@ package archiver
  * Tests for create.go
  * By J. Stuart McMurray
  * Created 20240812
- * Last Modified 20240812
+ * Last Modified 20261018
  */
 
 import (
 	"bytes"
 	"compress/gzip"
+	"errors"
 	"io"
 	"io/fs"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
+
+	"golang.org/x/tools/txtar"
 )
 
 // TestArchiverCreate tests archive creation
@@ -108,3 +117,436 @@ func (act archiverCreateTester) Do(t *testing.T) {
 		}
 	})
 }
+
+// TestArchiverCreate_NotSelf tests that the archive file isn't added to
+// itself, even under a different name.
+func TestArchiverCreate_NotSelf(t *testing.T) {
+	/* Directory with a file, an old archive, and a link to the archive. */
+	td := t.TempDir()
+	fn := filepath.Join(td, "file")
+	if err := os.WriteFile(fn, []byte("file\n"), 0600); nil != err {
+		t.Fatalf("Error creating file %s: %s", fn, err)
+	}
+	an := filepath.Join(td, "out.txtar")
+	if err := os.WriteFile(an, []byte("-- old --\n"), 0600); nil != err {
+		t.Fatalf("Error creating old archive %s: %s", an, err)
+	}
+	if err := os.Link(an, filepath.Join(td, "link.txtar")); nil != err {
+		t.Fatalf("Error linking to old archive: %s", err)
+	}
+
+	/* Archive the directory into itself. */
+	a := Archiver{Filename: an, Paths: []string{td}}
+	if err := a.Create(); nil != err {
+		t.Fatalf("Create failed: %s", err)
+	}
+	b, err := os.ReadFile(an)
+	if nil != err {
+		t.Fatalf("Error reading created archive: %s", err)
+	}
+	ta := txtar.Parse(b)
+	if 1 != len(ta.Files) || a.FromHostPath(fn) != ta.Files[0].Name {
+		t.Fatalf("Incorrect created archive:\n%s", b)
+	}
+}
+
+// TestArchiverCreate_BadNames tests that names which can't be stored in a
+// txtar archive are caught.
+func TestArchiverCreate_BadNames(t *testing.T) {
+	/* Files, some of which have bad names. */
+	td := t.TempDir()
+	goods := []string{"good", "trailing --", "in -- side"}
+	bads := []string{"new\nline", " leading", "trailing ", "tab\t"}
+	for _, n := range slices.Concat(goods, bads) {
+		fn := filepath.Join(td, n)
+		if err := os.WriteFile(fn, []byte(n), 0600); nil != err {
+			t.Fatalf("Error creating %q: %s", fn, err)
+		}
+	}
+	a := Archiver{Paths: []string{"."}, fs: os.DirFS(td)}
+
+	t.Run("error", func(t *testing.T) {
+		a := a /* Test-local copy. */
+		a.Filename = filepath.Join(t.TempDir(), "got.txtar")
+		err := a.Create()
+		if !errors.Is(err, ErrBadName) {
+			t.Fatalf("Incorrect error: %v", err)
+		}
+		for _, n := range bads {
+			if !strings.Contains(err.Error(), strconv.Quote(n)) {
+				t.Errorf("Error missing %q: %s", n, err)
+			}
+		}
+		for _, n := range goods {
+			if strings.Contains(err.Error(), strconv.Quote(n)) {
+				t.Errorf("Error has good name %q: %s", n, err)
+			}
+		}
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		a := a /* Test-local copy. */
+		a.Filename = filepath.Join(t.TempDir(), "got.txtar")
+		a.SkipBadNames = true
+		if err := a.Create(); nil != err {
+			t.Fatalf("Create failed: %s", err)
+		}
+		b, err := os.ReadFile(a.Filename)
+		if nil != err {
+			t.Fatalf("Error reading created file: %s", err)
+		}
+		var got []string
+		for _, f := range txtar.Parse(b).Files {
+			got = append(got, f.Name)
+		}
+		if want := slices.Sorted(slices.Values(goods)); !slices.Equal(
+			got,
+			want,
+		) {
+			t.Fatalf(
+				"Incorrect names:\n got: %q\nwant: %q",
+				got,
+				want,
+			)
+		}
+	})
+}
+
+func TestTxtarNameProblem(t *testing.T) {
+	for have, wantOK := range map[string]bool{
+		"a":          true,
+		"a/b/c":      true,
+		"a --":       true,
+		"-- a":       true,
+		"a b":        true,
+		"":           false,
+		"a\nb":       false,
+		"a\n":        false,
+		" a":         false,
+		"a ":         false,
+		"a\r":        false,
+		"\ta":        false,
+		"a\u00a0":    false,
+		"a/b/c\n--":  false,
+		"dir/ space": true,
+	} {
+		if got := txtarNameProblem(have); wantOK != ("" == got) {
+			t.Errorf("Incorrect problem for %q: %q", have, got)
+		}
+	}
+}
+
+// TestArchiverCreate_Times tests only adding files modified at certain times.
+func TestArchiverCreate_Times(t *testing.T) {
+	/* Files with known modification times. */
+	td := t.TempDir()
+	base := time.Date(2024, 8, 19, 12, 0, 0, 0, time.UTC)
+	for i, n := range []string{"old", "middle", "new"} {
+		fn := filepath.Join(td, n)
+		if err := os.WriteFile(fn, []byte(n), 0600); nil != err {
+			t.Fatalf("Error creating %s: %s", fn, err)
+		}
+		mt := base.Add(time.Duration(i) * time.Hour)
+		if err := os.Chtimes(fn, mt, mt); nil != err {
+			t.Fatalf("Error setting times on %s: %s", fn, err)
+		}
+	}
+
+	cs := map[string]struct {
+		a    Archiver
+		want []string
+	}{
+		"none": {
+			want: []string{"middle", "new", "old"},
+		},
+		"newer": {
+			a:    Archiver{Newer: base},
+			want: []string{"middle", "new"},
+		},
+		"older": {
+			a:    Archiver{Older: base.Add(2 * time.Hour)},
+			want: []string{"middle", "old"},
+		},
+		"newer_and_older": {
+			a: Archiver{
+				Newer: base.Add(time.Minute),
+				Older: base.Add(2 * time.Hour),
+			},
+			want: []string{"middle"},
+		},
+	}
+	for name, c := range cs {
+		t.Run(name, func(t *testing.T) {
+			a := c.a
+			a.Paths = []string{"."}
+			a.fs = os.DirFS(td)
+			if got := testCreateNames(t, a); !slices.Equal(
+				got,
+				c.want,
+			) {
+				t.Fatalf(
+					"Incorrect names:\n got: %q\nwant: %q",
+					got,
+					c.want,
+				)
+			}
+		})
+	}
+}
+
+func TestParseTimeOrFile(t *testing.T) {
+	/* Reference file. */
+	fn := filepath.Join(t.TempDir(), "ref")
+	if err := os.WriteFile(fn, nil, 0600); nil != err {
+		t.Fatalf("Error creating reference file: %s", err)
+	}
+	mt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(fn, mt, mt); nil != err {
+		t.Fatalf("Error setting reference file times: %s", err)
+	}
+
+	for have, want := range map[string]time.Time{
+		fn: mt,
+		"2024-08-19T12:34:56Z": time.Date(
+			2024, 8, 19, 12, 34, 56, 0, time.UTC,
+		),
+		"2024-08-19T12:34:56-04:00": time.Date(
+			2024, 8, 19, 16, 34, 56, 0, time.UTC,
+		),
+		"2024-08-19": time.Date(2024, 8, 19, 0, 0, 0, 0, time.Local),
+		"2024-08-19 12:34": time.Date(
+			2024, 8, 19, 12, 34, 0, 0, time.Local,
+		),
+		"2024-08-19 12:34:56": time.Date(
+			2024, 8, 19, 12, 34, 56, 0, time.Local,
+		),
+	} {
+		got, err := ParseTimeOrFile(have)
+		if nil != err {
+			t.Errorf("Error parsing %q: %s", have, err)
+		} else if !got.Equal(want) {
+			t.Errorf(
+				"Incorrect time for %q:\n got: %s\nwant: %s",
+				have,
+				got,
+				want,
+			)
+		}
+	}
+
+	if _, err := ParseTimeOrFile("yesterday"); nil == err {
+		t.Errorf("No error parsing a bad time")
+	}
+}
+
+func TestIsBinary(t *testing.T) {
+	/* Almost-but-not-quite too much invalid UTF-8. */
+	notQuite := append(
+		bytes.Repeat([]byte{0xff}, BinarySniffLen/BinaryInvalidUTF8Ratio),
+		bytes.Repeat([]byte("a"), BinarySniffLen)...,
+	)
+	cs := map[string]struct {
+		have []byte
+		want bool
+	}{
+		"empty":     {have: nil, want: false},
+		"text":      {have: []byte("Hello, World!\n"), want: false},
+		"utf8":      {have: []byte("Héllo, 世界!\n"), want: false},
+		"nul":       {have: []byte("Hello\x00World\n"), want: true},
+		"elf":       {have: []byte("\x7fELF\x02\x01\x01\x00"), want: true},
+		"invalid":   {have: []byte("\xff\xfe\xfd\xfc text"), want: true},
+		"latin1":    {have: []byte("A caf\xe9 in a long sentence.\n")},
+		"not_quite": {have: notQuite, want: false},
+		"just_over": {have: append([]byte{0xff}, notQuite...), want: true},
+		"late_nul":  {have: slices.Concat(notQuite[1:], []byte{0})},
+		"truncation": {have: slices.Concat(
+			notQuite[:BinarySniffLen-1],
+			[]byte{0xe4, 0xb8},
+		)},
+	}
+	for name, c := range cs {
+		if got := isBinary(c.have); got != c.want {
+			t.Errorf("Incorrect result for %s: %t", name, got)
+		}
+	}
+}
+
+func TestArchiverCreate_SkipBinary(t *testing.T) {
+	a := Archiver{
+		Paths:      []string{"."},
+		SkipBinary: true,
+		fs: fstest.MapFS{
+			"a.go":  &fstest.MapFile{Data: []byte("package a\n")},
+			"a.out": &fstest.MapFile{Data: []byte("\x7fELF\x00\x00")},
+			"empty": &fstest.MapFile{},
+		},
+	}
+	want := []string{"a.go", "empty"}
+	if got := testCreateNames(t, a); !slices.Equal(got, want) {
+		t.Fatalf("Incorrect names:\n got: %q\nwant: %q", got, want)
+	}
+}
+
+func TestArchiverArchiveName(t *testing.T) {
+	cs := []struct {
+		prefix string
+		unsafe bool
+		have   string
+		want   string
+	}{
+		{"", false, "a/b", "a/b"},
+		{"p/", false, "a/b", "p/a/b"},
+		{"p-", false, "a/b", "p-a/b"},
+		{"p", false, "../a/b", "pa/b"},
+		{"../p/", false, "a", "p/a"},
+		{"/p/", false, "a", "p/a"},
+		{"p//", false, "a", "p/a"},
+		{"p/", false, "/a", "p/a"},
+		{"/p/", true, "a", "/p/a"},
+		{"../p/", true, "../a", "../p/../a"},
+		{"p//", true, "a", "p//a"},
+	}
+	for _, c := range cs {
+		a := Archiver{Prefix: c.prefix, UnsafePaths: c.unsafe}
+		if got := a.archiveName(c.have); got != c.want {
+			t.Errorf(
+				"Incorrect name\n"+
+					"prefix: %s\n"+
+					"unsafe: %t\n"+
+					"  have: %s\n"+
+					"   got: %s\n"+
+					"  want: %s",
+				c.prefix,
+				c.unsafe,
+				c.have,
+				got,
+				c.want,
+			)
+		}
+	}
+}
+
+func TestArchiverArchiveName_Renames(t *testing.T) {
+	a := Archiver{
+		Prefix: "p/",
+		hostRenames: map[string]string{
+			"a":   "renamed/a",
+			"b/c": "../up",
+			"e":   "/abs",
+		},
+	}
+	for have, want := range map[string]string{
+		"a":      "p/renamed/a",
+		"./b//c": "p/up",
+		"e":      "p/abs",
+		"keep":   "p/keep",
+	} {
+		if got := a.archiveName(have); got != want {
+			t.Errorf(
+				"Incorrect name for %s: got %s, want %s",
+				have,
+				got,
+				want,
+			)
+		}
+	}
+}
+
+func TestArchiverCreate_Extras(t *testing.T) {
+	a := Archiver{
+		Paths:     []string{"."},
+		Prefix:    "p/",
+		StdinName: "/cfg.json",
+		AddTexts: []string{
+			"a=replaced",
+			"notes/b.txt=b=c\nd",
+			"empty=",
+		},
+		fs: fstest.MapFS{
+			"a": &fstest.MapFile{Data: []byte("a\n")},
+			"x": &fstest.MapFile{Data: []byte("x\n")},
+		},
+		stdin: strings.NewReader("{}\n"),
+	}
+	a.Filename = filepath.Join(t.TempDir(), "got.txtar")
+	if err := a.Create(); nil != err {
+		t.Fatalf("Create failed: %s", err)
+	}
+	b, err := os.ReadFile(a.Filename)
+	if nil != err {
+		t.Fatalf("Error reading created file: %s", err)
+	}
+	got := txtar.Parse(b).Files
+	want := []txtar.File{
+		{Name: "p/x", Data: []byte("x\n")},
+		{Name: "p/cfg.json", Data: []byte("{}\n")},
+		{Name: "p/a", Data: []byte("replaced\n")},
+		{Name: "p/notes/b.txt", Data: []byte("b=c\nd\n")},
+		{Name: "p/empty", Data: nil},
+	}
+	if !slices.EqualFunc(got, want, func(g, w txtar.File) bool {
+		return g.Name == w.Name && bytes.Equal(g.Data, w.Data)
+	}) {
+		t.Fatalf("Incorrect files:\n got: %q\nwant: %q", got, want)
+	}
+
+	/* Bad texts. */
+	for _, s := range []string{"noequals", "=noname", " =space"} {
+		a := Archiver{AddTexts: []string{s}}
+		a.Filename = filepath.Join(t.TempDir(), "got.txtar")
+		if err := a.Create(); nil == err {
+			t.Errorf("No error adding text %q", s)
+		}
+	}
+}
+
+func TestArchiverCreate_AddCmds(t *testing.T) {
+	if _, err := exec.LookPath("go"); nil != err {
+		t.Skipf("Go not found: %s", err)
+	}
+	a := Archiver{
+		AddTexts: []string{"env=replaced"},
+		AddCmds:  []string{"version=go  version", "env=go env 'GOOS'"},
+		fs:       fstest.MapFS{},
+	}
+	a.Filename = filepath.Join(t.TempDir(), "got.txtar")
+	if err := a.Create(); nil != err {
+		t.Fatalf("Create failed: %s", err)
+	}
+	b, err := os.ReadFile(a.Filename)
+	if nil != err {
+		t.Fatalf("Error reading created file: %s", err)
+	}
+	got := txtar.Parse(b).Files
+	if 2 != len(got) {
+		t.Fatalf("Expected 2 files, got %d: %q", len(got), got)
+	}
+	if got := got[0]; "version" != got.Name ||
+		!bytes.HasPrefix(got.Data, []byte("go version ")) {
+		t.Errorf("Incorrect version file: %q", got)
+	}
+	if got, want := got[1], runtime.GOOS+"\n"; "env" != got.Name ||
+		want != string(got.Data) {
+		t.Errorf("Incorrect env file: %q", got)
+	}
+
+	/* Bad commands. */
+	for _, c := range []string{
+		"noequals",
+		"=go version",
+		"nocmd=",
+		"spaces= \t",
+		"fails=go kittens",
+		`unbalanced=go "version`,
+	} {
+		a := Archiver{AddCmds: []string{c}, fs: fstest.MapFS{}}
+		a.Filename = filepath.Join(t.TempDir(), "got.txtar")
+		err := a.Create()
+		if nil == err {
+			t.Errorf("No error adding command %q", c)
+		} else if "fails=go kittens" == c &&
+			!strings.Contains(err.Error(), "kittens") {
+			t.Errorf("Stderr not in error: %s", err)
+		}
+	}
+}
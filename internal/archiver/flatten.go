@@ -0,0 +1,132 @@
+package archiver
+
+/*
+ * flatten.go
+ * Extract files without their directories
+ * By J. Stuart McMurray
+ * Created 20261018
+ * Last Modified 20261018
+ */
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/txtar"
+)
+
+// FlattenPolicy says what to do when Archiver.Flatten is set and more than
+// one file would be extracted with the same name.
+type FlattenPolicy string
+
+// Flatten collision policies.  The empty policy is the same as FlattenError.
+const (
+	FlattenError  FlattenPolicy = "error"  /* Don't extract anything. */
+	FlattenSkip   FlattenPolicy = "skip"   /* Only extract the first. */
+	FlattenSuffix FlattenPolicy = "suffix" /* Number the rest. */
+)
+
+// ErrFlattenCollision is returned when Archiver.Flatten is set and more than
+// one file would be extracted with the same name.
+var ErrFlattenCollision = errors.New("flattened names collide")
+
+// ParseFlattenPolicy returns the FlattenPolicy named s.
+func ParseFlattenPolicy(s string) (FlattenPolicy, error) {
+	switch p := FlattenPolicy(s); p {
+	case FlattenError, FlattenSkip, FlattenSuffix:
+		return p, nil
+	default:
+		return "", fmt.Errorf(
+			"unknown policy %q (want %s, %s, or %s)",
+			s,
+			FlattenError,
+			FlattenSkip,
+			FlattenSuffix,
+		)
+	}
+}
+
+// resolveFlattened applies a.FlattenCollisions to the files in sel, which
+// will be extracted with the corresponding names in names.  It returns the
+// files and names which should be extracted.  If a.Flatten isn't set, sel
+// and names are returned unchanged.
+func (a Archiver) resolveFlattened(
+	sel []txtar.File,
+	names []string,
+) ([]txtar.File, []string, error) {
+	if !a.Flatten {
+		return sel, names, nil
+	}
+
+	/* Note all of the names, so suffixed names don't take a name we'll
+	need later. */
+	var (
+		all      = make(map[string]bool)
+		used     = make(map[string]string) /* Name -> entry. */
+		newSel   []txtar.File
+		newNames []string
+	)
+	for _, n := range names {
+		all[n] = true
+	}
+
+	for i, f := range sel {
+		n := names[i]
+		if prev, ok := used[n]; ok {
+			switch a.FlattenCollisions {
+			case "", FlattenError:
+				return nil, nil, fmt.Errorf(
+					"%w: %s and %s would both be "+
+						"extracted as %s",
+					ErrFlattenCollision,
+					prev,
+					f.Name,
+					n,
+				)
+			case FlattenSkip:
+				fmt.Fprintf(
+					os.Stderr,
+					"Warning: skipping %s: %s already "+
+						"extracted as %s\n",
+					f.Name,
+					prev,
+					n,
+				)
+				continue
+			case FlattenSuffix:
+				for j := 1; ; j++ {
+					sn := suffixName(n, j)
+					if _, ok := used[sn]; !ok && !all[sn] {
+						n = sn
+						break
+					}
+				}
+			default:
+				return nil, nil, fmt.Errorf(
+					"unknown flatten collision policy %q",
+					a.FlattenCollisions,
+				)
+			}
+		}
+		used[n] = f.Name
+		newSel = append(newSel, f)
+		newNames = append(newNames, n)
+	}
+
+	return newSel, newNames, nil
+}
+
+// suffixName inserts .n before hn's extension, if it has one, or appends it
+// otherwise.
+func suffixName(hn string, n int) string {
+	ext := filepath.Ext(hn)
+	base := strings.TrimSuffix(hn, ext)
+	if "" == base { /* Dotfile. */
+		base, ext = ext, ""
+	}
+	return base + "." + strconv.Itoa(n) + ext
+}
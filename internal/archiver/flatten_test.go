@@ -0,0 +1,175 @@
+package archiver
+
+/*
+ * flatten_test.go
+ * Tests for flatten.go
+ * By J. Stuart McMurray
+ * Created 20261018
+ * Last Modified 20261018
+ */
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/txtar"
+)
+
+func TestSuffixName(t *testing.T) {
+	for _, c := range []struct {
+		have string
+		n    int
+		want string
+	}{
+		{"x.golden", 1, "x.1.golden"},
+		{"x.tar.gz", 2, "x.tar.2.gz"},
+		{"Makefile", 1, "Makefile.1"},
+		{".bashrc", 3, ".bashrc.3"},
+		{"a.", 1, "a.1."},
+	} {
+		if got := suffixName(c.have, c.n); got != c.want {
+			t.Errorf(
+				"suffixName(%q, %d): got %q, want %q",
+				c.have,
+				c.n,
+				got,
+				c.want,
+			)
+		}
+	}
+}
+
+func TestParseFlattenPolicy(t *testing.T) {
+	for _, s := range []string{"error", "skip", "suffix"} {
+		if p, err := ParseFlattenPolicy(s); nil != err {
+			t.Errorf("Error parsing %s: %s", s, err)
+		} else if string(p) != s {
+			t.Errorf("Parsed %s as %s", s, p)
+		}
+	}
+	if _, err := ParseFlattenPolicy("kittens"); nil == err {
+		t.Errorf("No error parsing unknown policy")
+	}
+}
+
+func TestArchiverListExtract_Flatten(t *testing.T) {
+	/* Archive with colliding base names. */
+	td := t.TempDir()
+	fn := filepath.Join(td, "a.txtar")
+	if err := os.WriteFile(fn, txtar.Format(&txtar.Archive{
+		Files: []txtar.File{
+			{Name: "a/x.golden", Data: []byte("a\n")},
+			{Name: "b/x.golden", Data: []byte("b\n")},
+			{Name: "c/d/x.golden", Data: []byte("c\n")},
+			{Name: "x.1.golden", Data: []byte("1\n")},
+			{Name: "y", Data: []byte("y\n")},
+		},
+	}), 0600); nil != err {
+		t.Fatalf("Error writing archive: %s", err)
+	}
+
+	t.Run("error", func(t *testing.T) {
+		for _, p := range []FlattenPolicy{"", FlattenError} {
+			a := Archiver{
+				Filename:          fn,
+				Flatten:           true,
+				FlattenCollisions: p,
+			}
+			xd := filepath.Join(t.TempDir(), "x")
+			err := a.ListOrExtract(new(bytes.Buffer), xd, true)
+			if !errors.Is(err, ErrFlattenCollision) {
+				t.Errorf("Policy %q: incorrect error: %v", p, err)
+			}
+			if _, err := os.Stat(xd); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("Policy %q: extracted files", p)
+			}
+		}
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		a := Archiver{
+			Filename:          fn,
+			Flatten:           true,
+			FlattenCollisions: FlattenSkip,
+		}
+		xd := t.TempDir()
+		if err := a.ListOrExtract(
+			new(bytes.Buffer),
+			xd,
+			true,
+		); nil != err {
+			t.Fatalf("Extraction failed: %s", err)
+		}
+		b, err := os.ReadFile(filepath.Join(xd, "x.golden"))
+		if nil != err {
+			t.Fatalf("Error reading x.golden: %s", err)
+		}
+		if got, want := string(b), "a\n"; got != want {
+			t.Errorf("Incorrect x.golden: got %q, want %q", got, want)
+		}
+		des, err := os.ReadDir(xd)
+		if nil != err {
+			t.Fatalf("Error reading directory: %s", err)
+		}
+		var got []string
+		for _, de := range des {
+			got = append(got, de.Name())
+		}
+		want := []string{"x.1.golden", "x.golden", "y"}
+		if !slices.Equal(got, want) {
+			t.Errorf("Incorrect files:\n got: %q\nwant: %q", got, want)
+		}
+	})
+
+	t.Run("suffix", func(t *testing.T) {
+		a := Archiver{
+			Filename:          fn,
+			Flatten:           true,
+			FlattenCollisions: FlattenSuffix,
+			Verbose:           true,
+		}
+		var (
+			xd  = t.TempDir()
+			out bytes.Buffer
+		)
+		if err := a.ListOrExtract(&out, xd, true); nil != err {
+			t.Fatalf("Extraction failed: %s", err)
+		}
+		got := strings.Split(strings.TrimSpace(out.String()), "\n")
+		want := []string{
+			"-No Comment-",
+			"",
+			"x.golden",
+			"x.2.golden",
+			"x.3.golden",
+			"x.1.golden",
+			"y",
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("Incorrect output:\n got: %q\nwant: %q", got, want)
+		}
+		for n, want := range map[string]string{
+			"x.golden":   "a\n",
+			"x.2.golden": "b\n",
+			"x.3.golden": "c\n",
+			"x.1.golden": "1\n",
+		} {
+			b, err := os.ReadFile(filepath.Join(xd, n))
+			if nil != err {
+				t.Errorf("Error reading %s: %s", n, err)
+			} else if string(b) != want {
+				t.Errorf(
+					"Incorrect %s: got %q, want %q",
+					n,
+					b,
+					want,
+				)
+			}
+		}
+	})
+}
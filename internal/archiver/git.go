@@ -0,0 +1,196 @@
+package archiver
+
+/*
+ * git.go
+ * Add files from a git commit
+ * By J. Stuart McMurray
+ * Created 20261018
+ * Last Modified 20261018
+ */
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/txtar"
+)
+
+// gitBlob is a file in a git tree.
+type gitBlob struct {
+	name string /* Relative to the current directory. */
+	oid  string
+}
+
+// addFromGit adds the regular files in the git commit a.GitRev under the
+// paths in a.Paths and a.LiteralPaths to ta, and returns the commit's ID.  As
+// with git ls-tree, paths are relative to the current directory, and no paths
+// means everything under the current directory.  Files are excluded and
+// included as in addToArchive, but ignore files aren't used.
+func (a Archiver) addFromGit(ta *txtar.Archive) (string, error) {
+	/* Work out which commit we're after. */
+	out, err := a.git(
+		"rev-parse",
+		"--verify",
+		"--end-of-options",
+		a.GitRev+"^{commit}",
+	)
+	if nil != err {
+		return "", fmt.Errorf("finding commit %s: %w", a.GitRev, err)
+	}
+	id := strings.TrimSpace(string(out))
+
+	/* Work out which files we'll want. */
+	if out, err = a.git(append(
+		[]string{"ls-tree", "-r", "-z", id, "--"},
+		slices.Concat(a.Paths, a.LiteralPaths)...,
+	)...); nil != err {
+		return "", fmt.Errorf("listing files in %s: %w", id, err)
+	}
+	var blobs []gitBlob
+	for _, ent := range strings.Split(string(out), "\x00") {
+		if "" == ent {
+			continue
+		}
+		/* Entries look like mode type oid\tname. */
+		meta, name, ok := strings.Cut(ent, "\t")
+		parts := strings.Fields(meta)
+		if !ok || 3 != len(parts) {
+			return "", fmt.Errorf("unexpected ls-tree entry %q", ent)
+		}
+		/* Don't really care about non-regular files. */
+		if "blob" != parts[1] ||
+			("100644" != parts[0] && "100755" != parts[0]) {
+			continue
+		}
+		/* Skip excluded and not-included files. */
+		name = filepath.FromSlash(name)
+		if excl, err := a.isExcluded(name); nil != err {
+			return "", fmt.Errorf(
+				"checking if %s is excluded: %w",
+				name,
+				err,
+			)
+		} else if excl {
+			continue
+		}
+		if incl, err := a.isIncluded(name); nil != err {
+			return "", fmt.Errorf(
+				"checking if %s is included: %w",
+				name,
+				err,
+			)
+		} else if !incl {
+			continue
+		}
+		blobs = append(blobs, gitBlob{name: name, oid: parts[2]})
+	}
+
+	/* Get the files' contents and add them. */
+	bs, err := a.gitBlobs(blobs)
+	if nil != err {
+		return "", fmt.Errorf("getting file contents: %w", err)
+	}
+	for i, blob := range blobs {
+		if a.skipBinary(blob.name, bs[i]) {
+			continue
+		}
+		a.addFile(ta, blob.name, bs[i])
+	}
+
+	return id, nil
+}
+
+// gitBlobs gets the contents of blobs, all at once.
+func (a Archiver) gitBlobs(blobs []gitBlob) ([][]byte, error) {
+	if 0 == len(blobs) {
+		return nil, nil
+	}
+
+	/* Ask git for all the blobs. */
+	var ids strings.Builder
+	for _, blob := range blobs {
+		ids.WriteString(blob.oid + "\n")
+	}
+	cmd := a.gitCommand("cat-file", "--batch")
+	cmd.Stdin = strings.NewReader(ids.String())
+	out, err := runCommand(cmd)
+	if nil != err {
+		return nil, err
+	}
+
+	/* Read each blob, which looks like oid type size\ndata\n. */
+	var (
+		br = bufio.NewReader(bytes.NewReader(out))
+		bs = make([][]byte, len(blobs))
+	)
+	for i, blob := range blobs {
+		hdr, err := br.ReadString('\n')
+		if nil != err {
+			return nil, fmt.Errorf(
+				"reading header for %s: %w",
+				blob.name,
+				err,
+			)
+		}
+		parts := strings.Fields(hdr)
+		if 3 != len(parts) {
+			return nil, fmt.Errorf(
+				"unexpected header for %s: %q",
+				blob.name,
+				hdr,
+			)
+		}
+		size, err := strconv.Atoi(parts[2])
+		if nil != err {
+			return nil, fmt.Errorf(
+				"parsing size for %s: %w",
+				blob.name,
+				err,
+			)
+		}
+		bs[i] = make([]byte, size)
+		if _, err := io.ReadFull(br, bs[i]); nil != err {
+			return nil, fmt.Errorf("reading %s: %w", blob.name, err)
+		}
+		if _, err := br.Discard(1); nil != err { /* Newline. */
+			return nil, fmt.Errorf("reading %s: %w", blob.name, err)
+		}
+	}
+
+	return bs, nil
+}
+
+// git runs git with the given arguments and returns its output.
+func (a Archiver) git(args ...string) ([]byte, error) {
+	return runCommand(a.gitCommand(args...))
+}
+
+// runCommand runs cmd and returns its output.  The command's stderr is added
+// to any error.
+func runCommand(cmd *exec.Cmd) ([]byte, error) {
+	out, err := cmd.Output()
+	var ee *exec.ExitError
+	if errors.As(err, &ee) && 0 != len(ee.Stderr) {
+		return nil, fmt.Errorf(
+			"%w: %s",
+			err,
+			bytes.TrimSpace(ee.Stderr),
+		)
+	}
+	return out, err
+}
+
+// gitCommand returns an exec.Cmd which runs git with the given arguments.
+func (a Archiver) gitCommand(args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = a.gitDir
+	return cmd
+}
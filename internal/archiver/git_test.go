@@ -0,0 +1,192 @@
+package archiver
+
+/*
+ * git_test.go
+ * Tests for git.go
+ * By J. Stuart McMurray
+ * Created 20261018
+ * Last Modified 20261018
+ */
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/txtar"
+)
+
+// testGitRepo makes a git repository in a temporary directory with two
+// commits and a dirty working tree, and returns the repository's directory.
+func testGitRepo(t *testing.T) string {
+	if _, err := exec.LookPath("git"); nil != err {
+		t.Skipf("Git not found: %s", err)
+	}
+	td := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = td
+		cmd.Env = append(
+			os.Environ(),
+			"GIT_CONFIG_GLOBAL="+os.DevNull,
+			"GIT_CONFIG_NOSYSTEM=1",
+			"GIT_AUTHOR_NAME=Tester",
+			"GIT_AUTHOR_EMAIL=tester@example.com",
+			"GIT_COMMITTER_NAME=Tester",
+			"GIT_COMMITTER_EMAIL=tester@example.com",
+		)
+		if out, err := cmd.CombinedOutput(); nil != err {
+			t.Fatalf("Error running git %q: %s\n%s", args, err, out)
+		}
+	}
+	write := func(name, contents string, perm os.FileMode) {
+		fn := filepath.Join(td, name)
+		if err := os.MkdirAll(filepath.Dir(fn), 0700); nil != err {
+			t.Fatalf("Error making directory for %s: %s", fn, err)
+		}
+		if err := os.WriteFile(fn, []byte(contents), perm); nil != err {
+			t.Fatalf("Error writing %s: %s", fn, err)
+		}
+	}
+
+	/* First commit. */
+	git("init", "-q")
+	write("a.go", "a.go, first commit\n", 0644)
+	write("run.sh", "#!/bin/sh\n", 0755)
+	write("sub/b.go", "sub/b.go, first commit\n", 0644)
+	write("sub/c.txt", "sub/c.txt, first commit\n", 0644)
+	if err := os.Symlink("a.go", filepath.Join(td, "link")); nil != err {
+		t.Fatalf("Error making symlink: %s", err)
+	}
+	git("add", ".")
+	git("commit", "-q", "-m", "First commit")
+
+	/* Second commit. */
+	write("a.go", "a.go, second commit\n", 0644)
+	write("d.go", "d.go, second commit\n", 0644)
+	git("add", ".")
+	git("commit", "-q", "-m", "Second commit")
+
+	/* Dirty working tree. */
+	write("sub/b.go", "sub/b.go, dirty\n", 0644)
+	write("untracked.go", "untracked.go, dirty\n", 0644)
+
+	return td
+}
+
+func TestArchiverCreate_Git(t *testing.T) {
+	td := testGitRepo(t)
+	cs := map[string]struct {
+		a       Archiver
+		wantRev string
+		want    []txtar.File
+	}{
+		"head": {
+			a: Archiver{GitRev: "HEAD", Paths: []string{"."}},
+			want: []txtar.File{
+				{Name: "a.go", Data: []byte("a.go, second commit\n")},
+				{Name: "d.go", Data: []byte("d.go, second commit\n")},
+				{Name: "run.sh", Data: []byte("#!/bin/sh\n")},
+				{
+					Name: "sub/b.go",
+					Data: []byte("sub/b.go, first commit\n"),
+				},
+				{
+					Name: "sub/c.txt",
+					Data: []byte("sub/c.txt, first commit\n"),
+				},
+			},
+		},
+		"previous_subdirectory": {
+			a: Archiver{
+				GitRev:       "HEAD~1",
+				Paths:        []string{"sub", "a.go"},
+				Comment:      "Snapshot",
+				ExcludeGlobs: []string{"*.txt"},
+			},
+			want: []txtar.File{
+				{Name: "a.go", Data: []byte("a.go, first commit\n")},
+				{
+					Name: "sub/b.go",
+					Data: []byte("sub/b.go, first commit\n"),
+				},
+			},
+		},
+		"no_paths": {
+			a: Archiver{GitRev: "HEAD~1", IncludeGlobs: []string{"*.go"}},
+			want: []txtar.File{
+				{Name: "a.go", Data: []byte("a.go, first commit\n")},
+				{
+					Name: "sub/b.go",
+					Data: []byte("sub/b.go, first commit\n"),
+				},
+			},
+		},
+	}
+	for name, c := range cs {
+		t.Run(name, func(t *testing.T) {
+			/* Work out what commit we should have. */
+			cmd := exec.Command("git", "rev-parse", c.a.GitRev)
+			cmd.Dir = td
+			out, err := cmd.Output()
+			if nil != err {
+				t.Fatalf("Error getting commit ID: %s", err)
+			}
+			wantComment := "commit " + string(out)
+			if "" != c.a.Comment {
+				wantComment = c.a.Comment + "\n" + wantComment
+			}
+
+			/* Make the archive. */
+			a := c.a
+			a.gitDir = td
+			a.Filename = filepath.Join(t.TempDir(), "got.txtar")
+			if err := a.Create(); nil != err {
+				t.Fatalf("Create failed: %s", err)
+			}
+			b, err := os.ReadFile(a.Filename)
+			if nil != err {
+				t.Fatalf("Error reading created file: %s", err)
+			}
+			got := txtar.Parse(b)
+			if string(got.Comment) != wantComment {
+				t.Errorf(
+					"Incorrect comment:\n"+
+						" got: %q\n"+
+						"want: %q",
+					got.Comment,
+					wantComment,
+				)
+			}
+			if !slices.EqualFunc(
+				got.Files,
+				c.want,
+				func(a, b txtar.File) bool {
+					return a.Name == b.Name &&
+						string(a.Data) == string(b.Data)
+				},
+			) {
+				t.Errorf("Incorrect archive:\n%s", b)
+			}
+		})
+	}
+}
+
+func TestArchiverCreate_GitBadRev(t *testing.T) {
+	td := testGitRepo(t)
+	a := Archiver{
+		GitRev:   "nonexistent",
+		Filename: filepath.Join(t.TempDir(), "got.txtar"),
+		gitDir:   td,
+	}
+	err := a.Create()
+	if nil == err {
+		t.Fatalf("Create succeeded")
+	}
+	if !strings.Contains(err.Error(), "nonexistent") {
+		t.Errorf("Error doesn't name the commit: %s", err)
+	}
+}
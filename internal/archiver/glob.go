@@ -0,0 +1,73 @@
+package archiver
+
+/*
+ * glob.go
+ * Globs with recursive **'s
+ * By J. Stuart McMurray
+ * Created 20261018
+ * Last Modified 20261018
+ */
+
+import (
+	"path"
+	"strings"
+)
+
+// doubleStar is the path component which matches any number of path
+// components.
+const doubleStar = "**"
+
+// matchGlob is like path.Match, but a path component in pattern which is
+// exactly ** matches zero or more path components in name.  Anywhere else,
+// ** works like *.  Unlike path.Match, the entire pattern is checked for
+// validity, even if name doesn't match.
+func matchGlob(pattern, name string) (bool, error) {
+	/* Make sure the pattern's sane. */
+	pcs := strings.Split(pattern, "/")
+	for _, pc := range pcs {
+		if doubleStar == pc {
+			continue
+		}
+		if _, err := path.Match(pc, ""); nil != err {
+			return false, err
+		}
+	}
+	return matchComponents(pcs, strings.Split(name, "/"))
+}
+
+// matchComponents matches the glob components pcs against the name
+// components ncs.
+func matchComponents(pcs, ncs []string) (bool, error) {
+	for 0 != len(pcs) {
+		/* A ** matches anything, so try the rest of the pattern at
+		every point in the rest of the name. */
+		if doubleStar == pcs[0] {
+			for 1 < len(pcs) && doubleStar == pcs[1] {
+				pcs = pcs[1:]
+			}
+			pcs = pcs[1:]
+			if 0 == len(pcs) { /* Trailing **. */
+				return true, nil
+			}
+			for i := range len(ncs) + 1 {
+				if ok, err := matchComponents(
+					pcs,
+					ncs[i:],
+				); nil != err || ok {
+					return ok, err
+				}
+			}
+			return false, nil
+		}
+		/* Normal glob components just need to match. */
+		if 0 == len(ncs) {
+			return false, nil
+		}
+		if ok, err := path.Match(pcs[0], ncs[0]); nil != err || !ok {
+			return false, err
+		}
+		pcs, ncs = pcs[1:], ncs[1:]
+	}
+	/* Pattern's done; name should be too. */
+	return 0 == len(ncs), nil
+}
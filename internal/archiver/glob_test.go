@@ -0,0 +1,140 @@
+package archiver
+
+/*
+ * glob_test.go
+ * Tests for glob.go
+ * By J. Stuart McMurray
+ * Created 20261018
+ * Last Modified 20261018
+ */
+
+import (
+	"errors"
+	"path"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	cs := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		/* Plain globs work like path.Match. */
+		{"a", "a", true},
+		{"a", "b", false},
+		{"*", "a", true},
+		{"*", "a/b", false},
+		{"a/*", "a/b", true},
+		{"a/*", "a/b/c", false},
+		{"*/b", "a/b", true},
+		{"a?c", "abc", true},
+		{"[ab]", "b", true},
+		{"[^ab]", "b", false},
+		{`\*`, "*", true},
+		{`\*`, "a", false},
+		{"", "", true},
+		{"", "a", false},
+
+		/* A lone ** matches everything. */
+		{"**", "", true},
+		{"**", "a", true},
+		{"**", "a/b/c", true},
+		{"**", ".hidden/x", true},
+
+		/* Leading **. */
+		{"**/a", "a", true},
+		{"**/a", "x/a", true},
+		{"**/a", "x/y/z/a", true},
+		{"**/a", "x/a/y", false},
+		{"**/a", "xa", false},
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "internal/archiver/glob.go", true},
+		{"**/*.go", "internal/archiver/glob.c", false},
+
+		/* Trailing **. */
+		{"a/**", "a", true},
+		{"a/**", "a/b", true},
+		{"a/**", "a/b/c", true},
+		{"a/**", "ab", false},
+		{"a/**", "b/a/c", false},
+
+		/* Middle **. */
+		{"a/**/b", "a/b", true},
+		{"a/**/b", "a/x/b", true},
+		{"a/**/b", "a/x/y/b", true},
+		{"a/**/b", "a/x/y/b/c", false},
+		{"a/**/b", "a/b/b", true},
+		{"a/**/b", "x/a/b", false},
+		{"testdata/**/*.golden", "testdata/x.golden", true},
+		{"testdata/**/*.golden", "testdata/a/b/x.golden", true},
+		{"testdata/**/*.golden", "testdata/a/b/x.go", false},
+		{"testdata/**/*.golden", "x/testdata/a/x.golden", false},
+
+		/* Several **'s. */
+		{"**/**", "a/b", true},
+		{"**/**/a", "a", true},
+		{"a/**/b/**/c", "a/b/c", true},
+		{"a/**/b/**/c", "a/x/b/y/z/c", true},
+		{"a/**/b/**/c", "a/x/c/y/b", false},
+		{"**/b/**", "a/b/c", true},
+		{"**/b/**", "b", true},
+		{"**/b/**", "a/c", false},
+
+		/* ** not alone in a component is just *. */
+		{"a**", "abc", true},
+		{"a**", "a/b", false},
+		{"**.go", "x.go", true},
+		{"**.go", "a/x.go", false},
+		{"a/**b", "a/x/b", false},
+
+		/* Absolute paths. */
+		{"/**/a", "/x/a", true},
+		{"**/a", "/x/a", true},
+		{"/a/**", "a/b", false},
+	}
+	for _, c := range cs {
+		got, err := matchGlob(c.pattern, c.name)
+		if nil != err {
+			t.Errorf(
+				"Error matching %q against %q: %s",
+				c.name,
+				c.pattern,
+				err,
+			)
+		} else if got != c.want {
+			t.Errorf(
+				"Incorrect match of %q against %q: %t",
+				c.name,
+				c.pattern,
+				got,
+			)
+		}
+	}
+}
+
+func TestMatchGlob_BadPattern(t *testing.T) {
+	for _, pattern := range []string{
+		"[",
+		"a/[",
+		"**/[",
+		"[/**",
+		"x/**/a[-]",
+		`a\`,
+	} {
+		for _, name := range []string{"", "a", "b/c", "x/y/z"} {
+			if _, err := matchGlob(
+				pattern,
+				name,
+			); !errors.Is(err, path.ErrBadPattern) {
+				t.Errorf(
+					"Incorrect error matching %q "+
+						"against %q: %v",
+					name,
+					pattern,
+					err,
+				)
+			}
+		}
+	}
+}
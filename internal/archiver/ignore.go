@@ -0,0 +1,261 @@
+package archiver
+
+/*
+ * ignore.go
+ * Per-directory ignore files
+ * By J. Stuart McMurray
+ * Created 20261018
+ * Last Modified 20261018
+ */
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	// IgnoreFileName is the name of the files from which exclude globs
+	// are read while walking directories, if Archiver.UseIgnoreFiles is
+	// set.  Globs in an ignore file only apply to paths in the ignore
+	// file's directory.
+	IgnoreFileName = ".mqtxtarignore"
+	// GitignoreFileName is the name of the files from which gitignore
+	// rules are read while walking directories, if Archiver.UseGitignore
+	// is set.
+	GitignoreFileName = ".gitignore"
+)
+
+// ignoreRule is a single rule from an ignore file.
+type ignoreRule struct {
+	glob     string
+	negate   bool /* Un-ignores, for gitignore. */
+	dirOnly  bool /* Only matches directories, for gitignore. */
+	anchored bool /* Matches the whole path, for gitignore. */
+}
+
+// ignorer keeps track of the rules in the ignore files found while walking a
+// directory tree.
+type ignorer struct {
+	a     Archiver
+	name  string                  /* Ignore file name. */
+	git   bool                    /* Gitignore rules. */
+	rules map[string][]ignoreRule /* Directory -> Rules. */
+}
+
+// newIgnorer returns a new ignorer, ready for use, which reads ignore files
+// named name.  If git is true, the files are read as gitignore files.
+func newIgnorer(a Archiver, name string, git bool) *ignorer {
+	return &ignorer{
+		a:     a,
+		name:  name,
+		git:   git,
+		rules: make(map[string][]ignoreRule),
+	}
+}
+
+// load loads the rules in the ignore file in the directory dir, if there is
+// one.
+func (ig *ignorer) load(dir string) error {
+	fn := filepath.Join(dir, ig.name)
+	b, err := ig.a.readFile(fn)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if nil != err {
+		return fmt.Errorf("reading %s: %w", fn, err)
+	}
+	var rs []ignoreRule
+	if ig.git {
+		rs = parseGitignore(b)
+	} else {
+		gs, err := readPatterns(bytes.NewReader(b))
+		if nil != err {
+			return fmt.Errorf("reading globs from %s: %w", fn, err)
+		}
+		for _, g := range gs {
+			rs = append(rs, ignoreRule{glob: g})
+		}
+	}
+	if 0 != len(rs) {
+		ig.rules[dir] = rs
+	}
+	return nil
+}
+
+// loadParents loads the ignore files in the directories above root, up to the
+// top of the git repository containing root, which may be above the current
+// directory.  Nothing is loaded if root isn't in a git repository, or for
+// non-gitignore ignorers.
+func (ig *ignorer) loadParents(root string) error {
+	if !ig.git {
+		return nil
+	}
+	/* Use absolute paths, so we can go above the current directory.  This
+	doesn't work for a.fs, but that's only for testing. */
+	dir := filepath.Clean(root)
+	if nil == ig.a.fs {
+		var err error
+		if dir, err = filepath.Abs(dir); nil != err {
+			return fmt.Errorf(
+				"getting absolute path of %s: %w",
+				root,
+				err,
+			)
+		}
+	}
+	/* Find the directories between root and the top of the repo. */
+	var dirs []string
+	for !ig.a.isRepoTop(dir) {
+		parent := filepath.Dir(dir)
+		if parent == dir || ".." == filepath.Base(parent) {
+			return nil /* Not in a repo. */
+		}
+		dir = parent
+		dirs = append(dirs, dir)
+	}
+	/* Load ignore files from them all. */
+	for _, dir := range dirs {
+		if err := ig.load(dir); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// isIgnored returns true if p is matched by a rule from an ignore file in one
+// of p's parent directories.  Paths are matched relative to the ignore file's
+// directory.  Rules in deeper directories and later in files take precedence.
+func (ig *ignorer) isIgnored(p string, isDir bool) (bool, error) {
+	/* Work out which ignore files apply, shallowest first.  The
+	shallower the directory, the longer the relative path. */
+	type applicable struct{ dir, rel string }
+	var (
+		as   []applicable
+		absP string /* For ignore files found by loadParents. */
+	)
+	for dir := range ig.rules {
+		tp := p
+		if filepath.IsAbs(dir) && !filepath.IsAbs(p) {
+			if "" == absP {
+				var err error
+				if absP, err = filepath.Abs(p); nil != err {
+					return false, fmt.Errorf(
+						"getting absolute path of "+
+							"%s: %w",
+						p,
+						err,
+					)
+				}
+			}
+			tp = absP
+		}
+		if rel := relUnder(dir, tp); "" != rel {
+			as = append(as, applicable{dir: dir, rel: rel})
+		}
+	}
+	slices.SortFunc(as, func(a, b applicable) int {
+		return len(b.rel) - len(a.rel)
+	})
+
+	/* Last match wins. */
+	var ignored bool
+	for _, ap := range as {
+		for _, r := range ig.rules[ap.dir] {
+			ok, err := ig.matches(r, ap.rel, isDir)
+			if nil != err {
+				return false, fmt.Errorf(
+					"matching %s from %s: %w",
+					r.glob,
+					filepath.Join(ap.dir, ig.name),
+					err,
+				)
+			} else if ok {
+				ignored = !r.negate
+			}
+		}
+	}
+	return ignored, nil
+}
+
+// matches returns true if r matches the /-path rel.  Gitignore rules work
+// like gitignore rules, other rules work like Archiver.isExcluded.
+func (ig *ignorer) matches(r ignoreRule, rel string, isDir bool) (bool, error) {
+	switch {
+	case !ig.git:
+		return ig.a.globMatches(r.glob, rel)
+	case r.dirOnly && !isDir:
+		return false, nil
+	case r.anchored:
+		return matchGlob(r.glob, rel)
+	default:
+		return path.Match(r.glob, path.Base(rel))
+	}
+}
+
+// relUnder returns p relative to dir as a /-path, or the empty string if p
+// isn't under dir.
+func relUnder(dir, p string) string {
+	rel, err := filepath.Rel(dir, p)
+	if nil != err {
+		return ""
+	}
+	rel = filepath.ToSlash(rel)
+	if "." == rel || ".." == rel || strings.HasPrefix(rel, "../") {
+		return ""
+	}
+	return rel
+}
+
+// parseGitignore parses the rules in a gitignore file.
+func parseGitignore(b []byte) []ignoreRule {
+	var rs []ignoreRule
+	for _, l := range strings.Split(string(b), "\n") {
+		l = strings.TrimSuffix(l, "\r")
+		/* Skip blanks and comments. */
+		if "" == l || strings.HasPrefix(l, "#") {
+			continue
+		}
+		/* Trailing spaces don't count, unless escaped. */
+		for strings.HasSuffix(l, " ") && !strings.HasSuffix(l, `\ `) {
+			l = strings.TrimSuffix(l, " ")
+		}
+		/* Work out what sort of rule this is. */
+		var r ignoreRule
+		if strings.HasPrefix(l, "!") {
+			r.negate = true
+			l = l[1:]
+		}
+		if strings.HasSuffix(l, "/") {
+			r.dirOnly = true
+			l = strings.TrimRight(l, "/")
+		}
+		if strings.Contains(l, "/") {
+			r.anchored = true
+			l = strings.TrimLeft(l, "/")
+		}
+		if "" == l {
+			continue
+		}
+		r.glob = l
+		rs = append(rs, r)
+	}
+	return rs
+}
+
+// isRepoTop returns true if dir is the top of a git repository.
+func (a Archiver) isRepoTop(dir string) bool {
+	fn := filepath.Join(dir, ".git")
+	var err error
+	if nil != a.fs {
+		_, err = fs.Stat(a.fs, fn)
+	} else {
+		_, err = os.Stat(fn)
+	}
+	return nil == err
+}
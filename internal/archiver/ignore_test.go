@@ -0,0 +1,305 @@
+package archiver
+
+/*
+ * ignore_test.go
+ * Tests for ignore.go
+ * By J. Stuart McMurray
+ * Created 20261018
+ * Last Modified 20261018
+ */
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"golang.org/x/tools/txtar"
+)
+
+// testCreateNames creates an archive with a and returns the names of the
+// files in it.
+func testCreateNames(t *testing.T, a Archiver) []string {
+	t.Helper()
+	a.Filename = filepath.Join(t.TempDir(), "got.txtar")
+	if err := a.Create(); nil != err {
+		t.Fatalf("Create failed: %s", err)
+	}
+	b, err := os.ReadFile(a.Filename)
+	if nil != err {
+		t.Fatalf("Error reading created file: %s", err)
+	}
+	var ns []string
+	for _, f := range txtar.Parse(b).Files {
+		ns = append(ns, f.Name)
+	}
+	return ns
+}
+
+func TestArchiverCreate_IgnoreFiles(t *testing.T) {
+	tfs := fstest.MapFS{
+		IgnoreFileName: &fstest.MapFile{
+			Data: []byte("# Build output\n*.o\n\nbuild\n"),
+		},
+		"a.c":                     &fstest.MapFile{},
+		"a.o":                     &fstest.MapFile{},
+		"build/a":                 &fstest.MapFile{},
+		"sub/b.c":                 &fstest.MapFile{},
+		"sub/b.o":                 &fstest.MapFile{},
+		"sub/b.tmp":               &fstest.MapFile{},
+		"sub/" + IgnoreFileName:   &fstest.MapFile{Data: []byte("*.tmp")},
+		"sub/inner/c.tmp":         &fstest.MapFile{},
+		"sub/inner/c.txt":         &fstest.MapFile{},
+		"other/d.tmp":             &fstest.MapFile{},
+		"other/only/e":            &fstest.MapFile{},
+		"other/" + IgnoreFileName: &fstest.MapFile{Data: []byte("only/*")},
+		"other/only/e.o":          &fstest.MapFile{},
+	}
+	cs := map[string]struct {
+		a    Archiver
+		want []string
+	}{
+		"honored": {
+			a: Archiver{UseIgnoreFiles: true},
+			want: []string{
+				IgnoreFileName,
+				"a.c",
+				"other/" + IgnoreFileName,
+				"other/d.tmp",
+				"sub/" + IgnoreFileName,
+				"sub/b.c",
+				"sub/inner/c.txt",
+			},
+		},
+		"not_honored": {
+			a: Archiver{},
+			want: []string{
+				IgnoreFileName,
+				"a.c",
+				"a.o",
+				"build/a",
+				"other/" + IgnoreFileName,
+				"other/d.tmp",
+				"other/only/e",
+				"other/only/e.o",
+				"sub/" + IgnoreFileName,
+				"sub/b.c",
+				"sub/b.o",
+				"sub/b.tmp",
+				"sub/inner/c.tmp",
+				"sub/inner/c.txt",
+			},
+		},
+	}
+	for name, c := range cs {
+		t.Run(name, func(t *testing.T) {
+			a := c.a
+			a.Paths = []string{"."}
+			a.fs = tfs
+			if got := testCreateNames(t, a); !slices.Equal(
+				got,
+				c.want,
+			) {
+				t.Fatalf(
+					"Incorrect names:\n got: %q\nwant: %q",
+					got,
+					c.want,
+				)
+			}
+		})
+	}
+}
+
+func TestArchiverCreate_Gitignore(t *testing.T) {
+	tfs := fstest.MapFS{
+		".git/HEAD": &fstest.MapFile{},
+		".gitignore": &fstest.MapFile{Data: []byte(`# Top-level
+*.log
+!keep.log
+build/
+/root_only
+docs/**/*.tmp
+trailing\ 
+`)},
+		"a.go":                &fstest.MapFile{},
+		"a.log":               &fstest.MapFile{},
+		"keep.log":            &fstest.MapFile{},
+		"root_only":           &fstest.MapFile{},
+		"trailing ":           &fstest.MapFile{},
+		"build/x":             &fstest.MapFile{},
+		"build/keep.log":      &fstest.MapFile{},
+		"docs/a/b/c.tmp":      &fstest.MapFile{},
+		"docs/c.tmp":          &fstest.MapFile{},
+		"docs/c.md":           &fstest.MapFile{},
+		"sub/root_only":       &fstest.MapFile{},
+		"sub/build":           &fstest.MapFile{}, /* Not a directory. */
+		"sub/b.log":           &fstest.MapFile{},
+		"sub/x.dat":           &fstest.MapFile{},
+		"sub/nested/y.dat":    &fstest.MapFile{},
+		"sub/nested/keep.dat": &fstest.MapFile{},
+		"sub/.gitignore": &fstest.MapFile{Data: []byte(
+			"*.dat\n!nested/keep.dat\n!b.log\n",
+		)},
+	}
+	cs := map[string]struct {
+		a     Archiver
+		paths []string
+		want  []string
+	}{
+		"whole_repo": {
+			a:     Archiver{UseGitignore: true},
+			paths: []string{"."},
+			want: []string{
+				".git/HEAD",
+				".gitignore",
+				"a.go",
+				"docs/c.md",
+				"keep.log",
+				"sub/.gitignore",
+				"sub/b.log",
+				"sub/build",
+				"sub/nested/keep.dat",
+				"sub/root_only",
+			},
+		},
+		"exclude_vcs": {
+			a:     Archiver{UseGitignore: true, ExcludeVCS: true},
+			paths: []string{"."},
+			want: []string{
+				"a.go",
+				"docs/c.md",
+				"keep.log",
+				"sub/b.log",
+				"sub/build",
+				"sub/nested/keep.dat",
+				"sub/root_only",
+			},
+		},
+		"subdirectory": {
+			a:     Archiver{UseGitignore: true},
+			paths: []string{"sub/nested", "docs"},
+			want: []string{
+				"sub/nested/keep.dat",
+				"docs/c.md",
+			},
+		},
+	}
+	for name, c := range cs {
+		t.Run(name, func(t *testing.T) {
+			a := c.a
+			a.Paths = c.paths
+			a.fs = tfs
+			if got := testCreateNames(t, a); !slices.Equal(
+				got,
+				c.want,
+			) {
+				t.Fatalf(
+					"Incorrect names:\n got: %q\nwant: %q",
+					got,
+					c.want,
+				)
+			}
+		})
+	}
+}
+
+func TestArchiverCreate_GitignoreFromSubdirectory(t *testing.T) {
+	/* Repo with ignore files above the directory we'll archive. */
+	td := t.TempDir()
+	for fn, content := range map[string]string{
+		".git/HEAD":        "",
+		".gitignore":       "*.log\n/top_only\n",
+		"sub/.gitignore":   "!keep.log\n",
+		"sub/a.go":         "",
+		"sub/a.log":        "",
+		"sub/keep.log":     "",
+		"sub/top_only":     "",
+		"sub/inner/b.log":  "",
+		"sub/inner/b.go":   "",
+		"sub/inner/x/c.go": "",
+	} {
+		fn = filepath.Join(td, filepath.FromSlash(fn))
+		if err := os.MkdirAll(filepath.Dir(fn), 0700); nil != err {
+			t.Fatalf("Error making directory for %s: %s", fn, err)
+		}
+		if err := os.WriteFile(fn, []byte(content), 0600); nil != err {
+			t.Fatalf("Error writing %s: %s", fn, err)
+		}
+	}
+
+	/* Archive from the subdirectory. */
+	wd, err := os.Getwd()
+	if nil != err {
+		t.Fatalf("Error getting working directory: %s", err)
+	}
+	if err := os.Chdir(filepath.Join(td, "sub")); nil != err {
+		t.Fatalf("Error changing directory: %s", err)
+	}
+	t.Cleanup(func() {
+		if err := os.Chdir(wd); nil != err {
+			t.Errorf("Error changing back to %s: %s", wd, err)
+		}
+	})
+	for _, c := range []struct {
+		paths []string
+		want  []string
+	}{{
+		paths: []string{"."},
+		want: []string{
+			".gitignore",
+			"a.go",
+			"inner/b.go",
+			"inner/x/c.go",
+			"keep.log",
+			"top_only",
+		},
+	}, {
+		paths: []string{"inner"},
+		want:  []string{"inner/b.go", "inner/x/c.go"},
+	}, {
+		paths: []string{filepath.Join("inner", "x")},
+		want:  []string{"inner/x/c.go"},
+	}} {
+		a := Archiver{Paths: c.paths, UseGitignore: true}
+		if got := testCreateNames(t, a); !slices.Equal(got, c.want) {
+			t.Errorf(
+				"Incorrect names for %q:\n got: %q\nwant: %q",
+				c.paths,
+				got,
+				c.want,
+			)
+		}
+	}
+}
+
+func TestParseGitignore(t *testing.T) {
+	have := "# Comment\n" +
+		"\n" +
+		"*.o\n" +
+		"!keep.o\n" +
+		"build/\n" +
+		"/top\n" +
+		"a/b\n" +
+		"**/deep/\n" +
+		"spaces  \n" +
+		"escaped\\ \n" +
+		"\\#hash\n" +
+		"crlf\r\n" +
+		"/\n"
+	want := []ignoreRule{
+		{glob: "*.o"},
+		{glob: "keep.o", negate: true},
+		{glob: "build", dirOnly: true},
+		{glob: "top", anchored: true},
+		{glob: "a/b", anchored: true},
+		{glob: "**/deep", anchored: true, dirOnly: true},
+		{glob: "spaces"},
+		{glob: "escaped\\ "},
+		{glob: "\\#hash"},
+		{glob: "crlf"},
+	}
+	if got := parseGitignore([]byte(have)); !slices.Equal(got, want) {
+		t.Fatalf("Incorrect rules:\n got: %+v\nwant: %+v", got, want)
+	}
+}
@@ -5,17 +5,20 @@ package archiver
  * List and/or extract archive contents
  * By J. Stuart McMurray
  * Created 20240813
- * Last Modified 20240819
+ * Last Modified 20261018
  */
 
 import (
-	"bytes"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"math"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 
 	"golang.org/x/tools/txtar"
 )
@@ -25,6 +28,25 @@ const (
 	CreateFilePerms = 0o0644
 	// CreateDirPerms are the permissions which which we create directories.
 	CreateDirPerms = 0o0755
+
+	// DefaultStdinMaxTotalSize is the default maximum uncompressed size
+	// of an archive read from stdin or gunzipped.
+	DefaultStdinMaxTotalSize = 1 << 30
+	// DefaultStdinMaxEntrySize is the default maximum size of a file in
+	// an archive read from stdin or gunzipped.
+	DefaultStdinMaxEntrySize = 1 << 28
+	// DefaultStdinMaxEntries is the default maximum number of files in an
+	// archive read from stdin or gunzipped.
+	DefaultStdinMaxEntries = 1 << 16
+)
+
+var (
+	// ErrLimitExceeded is returned when an archive is larger or has more
+	// files than is allowed.
+	ErrLimitExceeded = errors.New("limit exceeded")
+	// ErrNotFound is returned when a path to list or extract doesn't
+	// match anything in the archive.
+	ErrNotFound = errors.New("not found in archive")
 )
 
 // ListOrExtract lists and/or extracts the contents of a's archive file,
@@ -35,96 +57,337 @@ func (a Archiver) ListOrExtract(
 	where string,
 	doExtract bool,
 ) error {
-	/* Slurp file or stdin. */
+	/* Get the archive. */
+	ar, err := a.readArchive()
+	if nil != err {
+		return err
+	}
+
+	/* Work out which files we'll list or extract.  Selection uses the
+	names as they are in the archive, before transforms. */
 	var (
-		b   []byte
-		err error
+		sel        []txtar.File
+		matched    = make(map[string]bool)
+		litMatched = make(map[string]bool)
 	)
-	if "" == a.Filename { /* Just stdin. */
-		if b, err = io.ReadAll(os.Stdin); nil != err {
-			return fmt.Errorf("reading archive: %w", err)
+	for _, f := range ar.Files {
+		if ok, err := a.isSelected(
+			filepath.FromSlash(a.maybeSafenPath(f.Name)),
+			matched,
+			litMatched,
+		); nil != err {
+			return fmt.Errorf("processing %s: %w", f.Name, err)
+		} else if ok && "" != a.outputName(f.Name) {
+			sel = append(sel, f)
 		}
-	} else if nil == a.fs {
-		if b, err = os.ReadFile(a.Filename); nil != err {
-			return fmt.Errorf("reading %s: %w", a.Filename, err)
+	}
+
+	/* Work out the names to which we'll extract files, and make sure we
+	won't clobber files on case-insensitive filesystems, if we're
+	checking. */
+	names := make([]string, len(sel))
+	for i, f := range sel {
+		names[i] = a.outputName(f.Name)
+	}
+	if sel, names, err = a.resolveFlattened(sel, names); nil != err {
+		return err
+	}
+	if err := a.checkCollisions(
+		names,
+		doExtract && !a.ToStdout,
+	); nil != err {
+		return err
+	}
+
+	/* Print the comment, if we're verbose and it won't end up mixed in
+	with file contents. */
+	switch {
+	case doExtract && a.ToStdout: /* Just the files. */
+	case a.Verbose && 0 == len(ar.Comment):
+		_, err = fmt.Fprintf(w, "-No Comment-\n\n")
+	case a.Verbose:
+		_, err = fmt.Fprintf(w, "%s\n", ar.Comment)
+	}
+	if nil != err {
+		return err
+	}
+
+	/* Print and/or extract each allowed file plus maybe its size. */
+	for i, f := range sel {
+		if err := a.extractFromArchive(
+			w,
+			f,
+			names[i],
+			where,
+			doExtract,
+		); nil != err {
+			return fmt.Errorf("processing %s: %w", f.Name, err)
+		}
+	}
+
+	/* Note any paths we were asked for but didn't find. */
+	var nfErrs []error
+	for _, p := range a.Paths {
+		if matched[p] {
+			continue
+		}
+		nfErrs = append(nfErrs, fmt.Errorf("%s: %w", p, ErrNotFound))
+		matched[p] = true /* Only report once. */
+	}
+	for _, p := range a.LiteralPaths {
+		if litMatched[p] {
+			continue
 		}
-	} else {
-		if b, err = fs.ReadFile(a.fs, a.Filename); nil != err {
-			return fmt.Errorf("reading %s: %w", a.Filename, err)
+		nfErrs = append(nfErrs, fmt.Errorf("%s: %w", p, ErrNotFound))
+		litMatched[p] = true /* Only report once. */
+	}
+
+	return errors.Join(nfErrs...)
+}
+
+// readArchive reads and parses a's archive file, or stdin if a.Filename isn't
+// set.  The archive is gunzipped if a.WithGzip is set.  An error wrapping
+// ErrLimitExceeded is returned if the archive exceeds a's limits.
+func (a Archiver) readArchive() (*txtar.Archive, error) {
+	/* Open file or stdin. */
+	var r io.Reader = os.Stdin
+	if "" != a.Filename {
+		var (
+			f   fs.File
+			err error
+		)
+		if nil == a.fs {
+			f, err = os.Open(a.Filename)
+		} else {
+			f, err = a.fs.Open(a.Filename)
+		}
+		if nil != err {
+			return nil, fmt.Errorf("opening %s: %w", a.Filename, err)
 		}
+		defer f.Close()
+		r = f
 	}
 	/* Decompress, if we're doing that. */
 	if a.WithGzip {
-		zr, err := gzip.NewReader(bytes.NewReader(b))
+		zr, err := gzip.NewReader(r)
 		if nil != err {
-			return fmt.Errorf(
+			return nil, fmt.Errorf(
 				"initializing gunzipper: %w",
 				err,
 			)
 		}
-		if b, err = io.ReadAll(zr); nil != err {
-			return fmt.Errorf("gunzipping: %w", err)
-		}
+		r = zr
+	}
+	/* Slurp, but not too much. */
+	maxTotal, maxEntry, maxEntries := a.limits()
+	if 0 < maxTotal && math.MaxInt64 != maxTotal {
+		r = io.LimitReader(r, maxTotal+1)
+	}
+	b, err := io.ReadAll(r)
+	if nil != err {
+		return nil, fmt.Errorf("reading archive: %w", err)
+	}
+	if 0 < maxTotal && int64(len(b)) > maxTotal {
+		return nil, fmt.Errorf(
+			"%w: archive larger than maximum total size of "+
+				"%d bytes",
+			ErrLimitExceeded,
+			maxTotal,
+		)
 	}
 
 	/* Parse into an archive. */
 	ar := txtar.Parse(b)
 
-	/* Print the comment, if we're verbose. */
-	if a.Verbose && 0 == len(ar.Comment) {
-		if _, err := fmt.Fprintf(w, "-No Comment-\n\n"); nil != err {
-			return err
-		}
-	} else if a.Verbose {
-		if _, err := fmt.Fprintf(w, "%s\n", ar.Comment); nil != err {
-			return err
-		}
+	/* Make sure the files aren't too big or too many before we do
+	anything with them. */
+	if 0 < maxEntries && len(ar.Files) > maxEntries {
+		return nil, fmt.Errorf(
+			"%w: archive has %d entries, more than the maximum "+
+				"of %d",
+			ErrLimitExceeded,
+			len(ar.Files),
+			maxEntries,
+		)
 	}
-
-	/* Print and/or extract each allowed file plus maybe its size. */
 	for _, f := range ar.Files {
-		if err := a.extractFromArchive(
-			w,
-			f,
-			where,
-			doExtract,
-		); nil != err {
-			return fmt.Errorf("processing %s: %w", f.Name, err)
+		if 0 < maxEntry && int64(len(f.Data)) > maxEntry {
+			return nil, fmt.Errorf(
+				"%w: %s is %d bytes, larger than maximum "+
+					"entry size of %d bytes",
+				ErrLimitExceeded,
+				f.Name,
+				len(f.Data),
+				maxEntry,
+			)
 		}
 	}
 
-	return nil
+	return ar, nil
 }
 
-// listOrExtractFromArchive lists or extracts f.  Listing output is written to
-// w.
-func (a Archiver) extractFromArchive(
-	w io.Writer,
-	f txtar.File,
-	where string,
-	doExtract bool,
-) error {
-	/* Work out what we'll call this file locally. */
-	hn := a.ToHostPath(f.Name)
+// limits returns the maximum total uncompressed archive size, maximum entry
+// size, and maximum number of entries.  Unset limits get defaults if the
+// archive is read from stdin or is gzipped, as a small gzipped file may
+// decompress to something huge.  Non-positive limits mean no limit.
+func (a Archiver) limits() (maxTotal, maxEntry int64, maxEntries int) {
+	maxTotal, maxEntry, maxEntries =
+		a.MaxTotalSize, a.MaxEntrySize, a.MaxEntries
+	if "" != a.Filename && !a.WithGzip {
+		return
+	}
+	if 0 == maxTotal {
+		maxTotal = DefaultStdinMaxTotalSize
+	}
+	if 0 == maxEntry {
+		maxEntry = DefaultStdinMaxEntrySize
+	}
+	if 0 == maxEntries {
+		maxEntries = DefaultStdinMaxEntries
+	}
+	return
+}
 
-	/* Skip excluded files and files not on our list, if we have one. */
+// isSelected returns true if the file with host path hn isn't excluded, is
+// included, and is selected by a.Paths or a.LiteralPaths, if we have any.
+// Every path in a.Paths which matches hn is set to true in matched, and every
+// path in a.LiteralPaths equal to hn is set to true in litMatched.
+func (a Archiver) isSelected(
+	hn string,
+	matched map[string]bool,
+	litMatched map[string]bool,
+) (bool, error) {
+	/* Skip excluded files. */
 	if excl, err := a.isExcluded(hn); nil != err {
-		return fmt.Errorf("checking if %s is excluded: %w", hn, err)
+		return false, fmt.Errorf(
+			"checking if %s is excluded: %w",
+			hn,
+			err,
+		)
 	} else if excl {
-		return nil
+		return false, nil
+	}
+
+	/* Skip files which aren't included, if we're including. */
+	if incl, err := a.isIncluded(hn); nil != err {
+		return false, fmt.Errorf(
+			"checking if %s is included: %w",
+			hn,
+			err,
+		)
+	} else if !incl {
+		return false, nil
 	}
 
 	/* And, if we have a file list, only those. */
+	if 0 == len(a.Paths) && 0 == len(a.LiteralPaths) {
+		return true, nil
+	}
 	var found bool
+	for _, p := range a.LiteralPaths {
+		if p == hn || a.IgnoreCase && strings.EqualFold(p, hn) {
+			found = true
+			litMatched[p] = true
+		}
+	}
 	for _, g := range a.Paths {
-		if ok, err := filepath.Match(g, hn); nil != err {
-			return fmt.Errorf("invalid glob %s: %s", g, err)
+		if ok, err := a.pathSelects(g, hn); nil != err {
+			return false, fmt.Errorf("invalid glob %s: %s", g, err)
 		} else if ok {
 			found = true
-			break
+			matched[g] = true
+		}
+	}
+	return found, nil
+}
+
+// pathSelects returns true if p selects the host path hn.  If p is a glob it
+// must match all of hn, with ** matching any number of directories.
+// Otherwise p selects itself and, like tar, everything under it.  If
+// a.IgnoreCase is set, case is ignored.
+func (a Archiver) pathSelects(p, hn string) (bool, error) {
+	if a.IgnoreCase {
+		p, hn = strings.ToLower(p), strings.ToLower(hn)
+	}
+	/* Globs work like globs. */
+	if hasMeta(p) {
+		return matchGlob(filepath.ToSlash(p), filepath.ToSlash(hn))
+	}
+	/* Plain paths select a subtree. */
+	p = filepath.Clean(p)
+	if "." == p { /* Everything's under the current directory. */
+		return true, nil
+	}
+	return p == hn ||
+		strings.HasPrefix(hn, p+string(filepath.Separator)), nil
+}
+
+// hasMeta returns true if p has any glob metacharacters.
+func hasMeta(p string) bool {
+	magicChars := `*?[`
+	if '\\' != filepath.Separator {
+		magicChars = `*?[\`
+	}
+	return strings.ContainsAny(p, magicChars)
+}
+
+// outputName returns the host path to which the file named name in the
+// archive will be extracted.  If name is in a.Renames, it gets its host path
+// from there as-is, safened unless a.UnsafePaths is set.  Otherwise,
+// a.StripComponents leading path components are removed and a.Transforms are
+// applied.  If a.Flatten is set, only the last component is used.  If
+// nothing's left, the empty string is returned.
+func (a Archiver) outputName(name string) string {
+	if hp, ok := a.Renames[path.Clean(name)]; ok {
+		hp = a.maybeSafenPath(filepath.ToSlash(hp))
+		if "." == hp {
+			return ""
+		}
+		return filepath.FromSlash(hp)
+	}
+	p := a.maybeSafenPath(name)
+	if 0 < a.StripComponents {
+		parts := strings.FieldsFunc(p, func(r rune) bool {
+			return '/' == r
+		})
+		if len(parts) <= a.StripComponents {
+			return ""
 		}
+		p = strings.Join(parts[a.StripComponents:], "/")
 	}
-	if 0 != len(a.Paths) && !found {
+	if p = a.ToHostPath(p); "." == p {
+		return ""
+	}
+	if a.Flatten {
+		p = filepath.Base(p)
+	}
+	return p
+}
+
+// extractFromArchive lists or extracts f, which will be extracted with host
+// path hn.  Listing output is written to w.  If a.ToStdout is set, f's
+// contents are extracted to w, preceded by a header if a.Verbose is set.
+func (a Archiver) extractFromArchive(
+	w io.Writer,
+	f txtar.File,
+	hn string,
+	where string,
+	doExtract bool,
+) error {
+	/* If we're extracting to stdout, that's all we do. */
+	if doExtract && a.ToStdout {
+		if a.Verbose {
+			if _, err := fmt.Fprintf(
+				w,
+				"==> %s <==\n",
+				hn,
+			); nil != err {
+				return fmt.Errorf("writing header: %w", err)
+			}
+		}
+		if _, err := w.Write(f.Data); nil != err {
+			return fmt.Errorf("writing contents: %w", err)
+		}
 		return nil
 	}
 
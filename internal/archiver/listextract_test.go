@@ -5,15 +5,18 @@ package archiver
  * Tests for listextract.go
  * By J. Stuart McMurray
  * Created 20240819
- * Last Modified 20240819
+ * Last Modified 20261018
  */
 
 import (
 	"bytes"
+	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"maps"
+	"math"
 	"os"
 	"path/filepath"
 	"slices"
@@ -117,6 +120,16 @@ func (act archiverExtractTester) Do(t *testing.T) {
 	for _, f := range ta.Files {
 		tam[f.Name] = f.Data
 	}
+	/* If we've a list of files we should get, only expect those. */
+	wantName := name + ".list"
+	if wb, err := fs.ReadFile(a.fs, wantName); nil == err {
+		wants := strings.Fields(string(wb))
+		maps.DeleteFunc(tam, func(k string, _ []byte) bool {
+			return !slices.Contains(wants, k)
+		})
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Error reading want file %s: %s", wantName, err)
+	}
 
 	/* Make sure all the extracted files are in the archive. */
 	if err := fs.WalkDir(os.DirFS(td), ".", func(
@@ -175,3 +188,382 @@ func (act archiverExtractTester) Do(t *testing.T) {
 		t.Errorf("Did not extract %s", n)
 	}
 }
+
+// TestArchiverListExtract_Limits tests that too-large archives are refused.
+func TestArchiverListExtract_Limits(t *testing.T) {
+	/* Archive to test, with a gzip bomb-ish entry. */
+	ab := txtar.Format(&txtar.Archive{Files: []txtar.File{
+		{Name: "small", Data: []byte("small\n")},
+		{Name: "big", Data: bytes.Repeat([]byte("big\n"), 1<<18)},
+	}})
+	zbuf := new(bytes.Buffer)
+	zw := gzip.NewWriter(zbuf)
+	if _, err := zw.Write(ab); nil != err {
+		t.Fatalf("Error compressing archive: %s", err)
+	}
+	if err := zw.Close(); nil != err {
+		t.Fatalf("Error finishing compression: %s", err)
+	}
+	td := t.TempDir()
+	fn := filepath.Join(td, "a.txtar")
+	if err := os.WriteFile(fn, ab, 0600); nil != err {
+		t.Fatalf("Error writing archive: %s", err)
+	}
+	zfn := filepath.Join(td, "a.txtar.gz")
+	if err := os.WriteFile(zfn, zbuf.Bytes(), 0600); nil != err {
+		t.Fatalf("Error writing compressed archive: %s", err)
+	}
+
+	cs := map[string]struct {
+		a       Archiver
+		wantErr bool
+	}{
+		"no_limits": {
+			a: Archiver{},
+		},
+		"negative_limits": {
+			a: Archiver{
+				MaxTotalSize: -1,
+				MaxEntrySize: -1,
+				MaxEntries:   -1,
+			},
+		},
+		"large_limits": {
+			a: Archiver{
+				MaxTotalSize: 1 << 21,
+				MaxEntrySize: 1 << 20,
+				MaxEntries:   2,
+			},
+		},
+		"total_size": {
+			a:       Archiver{MaxTotalSize: 1 << 19},
+			wantErr: true,
+		},
+		"total_size_gzip": {
+			a:       Archiver{MaxTotalSize: 1 << 19, WithGzip: true},
+			wantErr: true,
+		},
+		"entry_size": {
+			a:       Archiver{MaxEntrySize: 1 << 19},
+			wantErr: true,
+		},
+		"entries": {
+			a:       Archiver{MaxEntries: 1},
+			wantErr: true,
+		},
+	}
+	for name, c := range cs {
+		t.Run(name, func(t *testing.T) {
+			a := c.a
+			a.Filename = fn
+			if a.WithGzip {
+				a.Filename = zfn
+			}
+			err := a.ListOrExtract(io.Discard, td, false)
+			if !c.wantErr && nil != err {
+				t.Fatalf("Error: %s", err)
+			} else if c.wantErr && !errors.Is(err, ErrLimitExceeded) {
+				t.Fatalf("Incorrect error: %v", err)
+			}
+		})
+	}
+}
+
+// TestArchiverLimits tests that reading from stdin gets default limits.
+func TestArchiverLimits(t *testing.T) {
+	type limits struct {
+		maxTotal   int64
+		maxEntry   int64
+		maxEntries int
+	}
+	cs := map[string]struct {
+		a    Archiver
+		want limits
+	}{
+		"file": {
+			a:    Archiver{Filename: "a.txtar"},
+			want: limits{},
+		},
+		"stdin": {
+			a: Archiver{},
+			want: limits{
+				DefaultStdinMaxTotalSize,
+				DefaultStdinMaxEntrySize,
+				DefaultStdinMaxEntries,
+			},
+		},
+		"stdin_set": {
+			a: Archiver{
+				MaxTotalSize: 10,
+				MaxEntrySize: -1,
+				MaxEntries:   5,
+			},
+			want: limits{10, -1, 5},
+		},
+		"gzipped_file": {
+			a: Archiver{Filename: "a.txtar.gz", WithGzip: true},
+			want: limits{
+				DefaultStdinMaxTotalSize,
+				DefaultStdinMaxEntrySize,
+				DefaultStdinMaxEntries,
+			},
+		},
+		"gzipped_file_set": {
+			a: Archiver{
+				Filename:     "a.txtar.gz",
+				WithGzip:     true,
+				MaxTotalSize: -1,
+			},
+			want: limits{
+				-1,
+				DefaultStdinMaxEntrySize,
+				DefaultStdinMaxEntries,
+			},
+		},
+	}
+	for name, c := range cs {
+		t.Run(name, func(t *testing.T) {
+			var got limits
+			got.maxTotal, got.maxEntry, got.maxEntries = c.a.limits()
+			if got != c.want {
+				t.Fatalf(
+					"Incorrect limits:\n got: %+v\nwant: %+v",
+					got,
+					c.want,
+				)
+			}
+		})
+	}
+}
+
+// TestArchiverListExtract_MaxLimit tests that the largest possible total
+// size limit doesn't overflow.
+func TestArchiverListExtract_MaxLimit(t *testing.T) {
+	a := Archiver{
+		Filename:     "simple.txtar",
+		MaxTotalSize: math.MaxInt64,
+		fs:           subFS(t, "archiver/listextract/list"),
+	}
+	want, err := fs.ReadFile(a.fs, "simple.list")
+	if nil != err {
+		t.Fatalf("Error reading want file: %s", err)
+	}
+	buf := new(bytes.Buffer)
+	if err := a.ListOrExtract(buf, "", false); nil != err {
+		t.Fatalf("Error: %s", err)
+	}
+	if got := buf.Bytes(); !bytes.Equal(got, want) {
+		t.Fatalf("Incorrect listing:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestArchiverListExtract_NotFound tests that paths which match nothing are
+// reported.
+func TestArchiverListExtract_NotFound(t *testing.T) {
+	a := Archiver{
+		Filename: "paths.txtar",
+		Paths:    []string{"a", "nope", "b/*.c", "*/*.x", "nope"},
+		fs:       subFS(t, "archiver/listextract/list"),
+	}
+	buf := new(bytes.Buffer)
+	err := a.ListOrExtract(buf, "", false)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Incorrect error: %v", err)
+	}
+	want := "nope: not found in archive\n*/*.x: not found in archive"
+	if got := err.Error(); got != want {
+		t.Errorf("Incorrect error:\n got: %s\nwant: %s", got, want)
+	}
+	want = "a\nb/b.c\nb/c.c\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Incorrect listing:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPathSelects(t *testing.T) {
+	cs := []struct {
+		p    string
+		hn   string
+		want bool
+	}{
+		{"a", "a", true},
+		{"a", "a/b", true},
+		{"a", "a/b/c", true},
+		{"a/", "a/b", true},
+		{"./a", "a/b", true},
+		{"a", "ab", false},
+		{"a", "ab/c", false},
+		{"a/b", "a", false},
+		{".", "a/b", true},
+		{"*", "a", true},
+		{"*", "a/b", false},
+		{"a/*", "a/b", true},
+		{"a/*", "a/b/c", false},
+		{"*.go", "a.go", true},
+		{"*.go", "a/b.go", false},
+		{"**/*.go", "a/b.go", true},
+		{"a/**", "a/b/c", true},
+		{"a/**/c", "a/b/c", true},
+		{"a/**/c", "b/a/c", false},
+	}
+	for _, c := range cs {
+		p, hn := filepath.FromSlash(c.p), filepath.FromSlash(c.hn)
+		got, err := Archiver{}.pathSelects(p, hn)
+		if nil != err {
+			t.Errorf("Error matching %q against %q: %s", hn, p, err)
+		} else if got != c.want {
+			t.Errorf("Incorrect match of %q against %q", hn, p)
+		}
+	}
+}
+
+// TestArchiverListExtract_StripComponents tests extracting with leading path
+// components removed.
+func TestArchiverListExtract_StripComponents(t *testing.T) {
+	/* Archive with a few levels. */
+	td := t.TempDir()
+	fn := filepath.Join(td, "a.txtar")
+	if err := os.WriteFile(fn, txtar.Format(&txtar.Archive{
+		Files: []txtar.File{
+			{Name: "top", Data: []byte("top\n")},
+			{Name: "/x/y/z", Data: []byte("z\n")},
+			{Name: "x/a", Data: []byte("a\n")},
+			{Name: "../x/y/b", Data: []byte("b\n")},
+		},
+	}), 0600); nil != err {
+		t.Fatalf("Error writing archive: %s", err)
+	}
+
+	/* Extract with components stripped. */
+	a := Archiver{Filename: fn, StripComponents: 2}
+	xd := filepath.Join(td, "x")
+	if err := a.ListOrExtract(io.Discard, xd, true); nil != err {
+		t.Fatalf("Extraction failed: %s", err)
+	}
+	var got []string
+	if err := fs.WalkDir(os.DirFS(xd), ".", func(
+		path string,
+		d fs.DirEntry,
+		err error,
+	) error {
+		if nil != err {
+			return err
+		}
+		if !d.IsDir() {
+			b, err := os.ReadFile(filepath.Join(xd, path))
+			if nil != err {
+				return err
+			}
+			got = append(got, path+": "+string(b))
+		}
+		return nil
+	}); nil != err {
+		t.Fatalf("Error walking extracted files: %s", err)
+	}
+	want := []string{"b: b\n", "z: z\n"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("Incorrect files:\n got: %q\nwant: %q", got, want)
+	}
+}
+
+func TestArchiverListExtract_ToStdout(t *testing.T) {
+	td := t.TempDir()
+	fn := filepath.Join(td, "a.txtar")
+	if err := os.WriteFile(fn, txtar.Format(&txtar.Archive{
+		Comment: []byte("Comment\n"),
+		Files: []txtar.File{
+			{Name: "a", Data: []byte("a\n")},
+			{Name: "b/c", Data: []byte("c\n")},
+			{Name: "d", Data: []byte("d\n")},
+		},
+	}), 0600); nil != err {
+		t.Fatalf("Error writing archive: %s", err)
+	}
+
+	for _, c := range []struct {
+		verbose bool
+		want    string
+	}{
+		{false, "a\nc\n"},
+		{true, "==> a <==\na\n==> b/c <==\nc\n"},
+	} {
+		a := Archiver{
+			Filename: fn,
+			Paths:    []string{"a", "b"},
+			ToStdout: true,
+			Verbose:  c.verbose,
+		}
+		var (
+			xd  = filepath.Join(td, "x")
+			out bytes.Buffer
+		)
+		if err := a.ListOrExtract(&out, xd, true); nil != err {
+			t.Fatalf("Extraction failed: %s", err)
+		}
+		if got := out.String(); got != c.want {
+			t.Errorf(
+				"Incorrect output (verbose:%t):\n"+
+					" got: %q\n"+
+					"want: %q",
+				c.verbose,
+				got,
+				c.want,
+			)
+		}
+		if _, err := os.Stat(xd); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Extracted files (verbose:%t)", c.verbose)
+		}
+	}
+}
+
+func TestArchiverListExtract_LiteralPaths(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "a.txtar")
+	if err := os.WriteFile(fn, txtar.Format(&txtar.Archive{
+		Files: []txtar.File{
+			{Name: "d"},
+			{Name: "d/foo[1].txt"},
+			{Name: "d/foo1.txt"},
+			{Name: "d/x"},
+			{Name: "E"},
+		},
+	}), 0600); nil != err {
+		t.Fatalf("Error writing archive: %s", err)
+	}
+	a := Archiver{
+		Filename: fn,
+		LiteralPaths: []string{
+			filepath.FromSlash("d/foo[1].txt"),
+			"d",
+			"e",
+			"missing",
+		},
+	}
+	var out bytes.Buffer
+	err := a.ListOrExtract(&out, "", false)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Incorrect error: %v", err)
+	}
+	for _, p := range []string{"e", "missing"} {
+		if !strings.Contains(err.Error(), p+": ") {
+			t.Errorf("Error does not mention %s: %s", p, err)
+		}
+	}
+	if strings.Contains(err.Error(), "d: ") {
+		t.Errorf("Error mentions found path: %s", err)
+	}
+	want := "d\n" + filepath.FromSlash("d/foo[1].txt") + "\n"
+	if got := out.String(); got != want {
+		t.Errorf("Incorrect listing:\n got: %q\nwant: %q", got, want)
+	}
+
+	/* Case-insensitively, E is there. */
+	a.IgnoreCase = true
+	out.Reset()
+	err = a.ListOrExtract(&out, "", false)
+	if nil == err || strings.Contains(err.Error(), "e: ") {
+		t.Errorf("Incorrect case-insensitive error: %v", err)
+	}
+	if got := out.String(); got != want+"E\n" {
+		t.Errorf("Incorrect case-insensitive listing: %q", got)
+	}
+}
@@ -0,0 +1,80 @@
+package archiver
+
+/*
+ * split.go
+ * Split commands into arguments, shell-style
+ * By J. Stuart McMurray
+ * Created 20261018
+ * Last Modified 20261018
+ */
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+var (
+	// ErrUnterminatedQuote is returned by splitCommand when a quote
+	// isn't closed.
+	ErrUnterminatedQuote = errors.New("unterminated quote")
+	// ErrTrailingBackslash is returned by splitCommand when a command
+	// ends with an unescaped backslash.
+	ErrTrailingBackslash = errors.New("trailing backslash")
+)
+
+// splitCommand splits s into arguments on whitespace, roughly like a POSIX
+// shell.  Text in single quotes is taken literally.  In double quotes, a
+// backslash escapes a ", \, $, or `.  Elsewhere, a backslash escapes any
+// character.  Nothing else, e.g. variable expansion or globbing, is done.
+func splitCommand(s string) ([]string, error) {
+	var (
+		args   []string
+		arg    strings.Builder
+		inArg  bool /* Tracks empty quoted args. */
+		quote  rune /* Current quote, or 0. */
+		escape bool /* Previous rune was a backslash. */
+	)
+	for _, r := range s {
+		switch {
+		case escape && '"' == quote: /* Only some escapes in "'s. */
+			if !strings.ContainsRune("\"\\$`", r) {
+				arg.WriteRune('\\')
+			}
+			arg.WriteRune(r)
+			escape = false
+		case escape:
+			arg.WriteRune(r)
+			escape = false
+		case '\'' == quote && '\'' == r, '"' == quote && '"' == r:
+			quote = 0
+		case '\'' == quote:
+			arg.WriteRune(r)
+		case '\\' == r:
+			escape, inArg = true, true
+		case '"' == quote:
+			arg.WriteRune(r)
+		case '\'' == r, '"' == r:
+			quote, inArg = r, true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	switch {
+	case escape:
+		return nil, ErrTrailingBackslash
+	case 0 != quote:
+		return nil, ErrUnterminatedQuote
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
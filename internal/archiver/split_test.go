@@ -0,0 +1,72 @@
+package archiver
+
+/*
+ * split_test.go
+ * Tests for split.go
+ * By J. Stuart McMurray
+ * Created 20261018
+ * Last Modified 20261018
+ */
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	for _, c := range []struct {
+		have string
+		want []string
+	}{
+		{"", nil},
+		{" \t ", nil},
+		{"go version", []string{"go", "version"}},
+		{"  uname\t-a  ", []string{"uname", "-a"}},
+		{`sh -c "echo hi"`, []string{"sh", "-c", "echo hi"}},
+		{`sh -c 'echo "hi"'`, []string{"sh", "-c", `echo "hi"`}},
+		{`a'b'"c"d`, []string{"abcd"}},
+		{`a '' ""`, []string{"a", "", ""}},
+		{`a\ b`, []string{"a b"}},
+		{`a\'b`, []string{"a'b"}},
+		{`'a\b'`, []string{`a\b`}},
+		{`"a\b"`, []string{`a\b`}},
+		{`"a\"b\\c\$d"`, []string{`a"b\c$d`}},
+		{`"a b" c`, []string{"a b", "c"}},
+		{`\\`, []string{`\`}},
+		{`"$HOME" *`, []string{"$HOME", "*"}},
+	} {
+		got, err := splitCommand(c.have)
+		if nil != err {
+			t.Errorf("Error splitting %q: %s", c.have, err)
+			continue
+		}
+		if !slices.Equal(got, c.want) {
+			t.Errorf(
+				"Incorrect split of %q:\n got: %q\nwant: %q",
+				c.have,
+				got,
+				c.want,
+			)
+		}
+	}
+}
+
+func TestSplitCommand_Errors(t *testing.T) {
+	for have, want := range map[string]error{
+		`sh -c "echo hi`: ErrUnterminatedQuote,
+		`'a`:             ErrUnterminatedQuote,
+		`"a\"`:           ErrUnterminatedQuote,
+		`a\`:             ErrTrailingBackslash,
+		`"a" \`:          ErrTrailingBackslash,
+	} {
+		if _, err := splitCommand(have); !errors.Is(err, want) {
+			t.Errorf(
+				"Incorrect error splitting %q: got %v, want %s",
+				have,
+				err,
+				want,
+			)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package archiver
+
+/*
+ * transform.go
+ * sed-like name transformations
+ * By J. Stuart McMurray
+ * Created 20261018
+ * Last Modified 20261018
+ */
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Transform is a sed-like s/regex/replacement/flags expression which rewrites
+// names, like tar's --transform.
+type Transform struct {
+	expr   string
+	re     *regexp.Regexp
+	repl   string /* For regexp.Expand. */
+	global bool
+}
+
+// ParseTransform parses a sed-like s/regex/replacement/flags expression.  Any
+// character may be used instead of the slashes, and may be escaped with a
+// backslash.  The regex is a Go regular expression.  In the replacement, &
+// is replaced with the matched text and \1 through \9 with submatches.  The
+// flags may be g, to replace all matches and not just the first, and i, to
+// ignore case.
+func ParseTransform(expr string) (*Transform, error) {
+	/* Should start with s and a delimiter. */
+	if !strings.HasPrefix(expr, "s") || 1 == len(expr) {
+		return nil, errors.New("transform must start with s and a delimiter")
+	}
+	delim, size := utf8.DecodeRuneInString(expr[1:])
+	if '\\' == delim || '\n' == delim {
+		return nil, fmt.Errorf("invalid delimiter %q", delim)
+	}
+
+	/* Split into the three parts. */
+	var (
+		parts []string
+		part  strings.Builder
+		rest  = expr[1+size:]
+	)
+	for 2 > len(parts) {
+		if "" == rest {
+			return nil, fmt.Errorf("missing delimiter %q", delim)
+		}
+		r, size := utf8.DecodeRuneInString(rest)
+		rest = rest[size:]
+		switch {
+		case delim == r: /* End of the part. */
+			parts = append(parts, part.String())
+			part.Reset()
+		case '\\' == r && strings.HasPrefix(rest, string(delim)):
+			/* Escaped delimiter. */
+			rest = rest[len(string(delim)):]
+			if 0 == len(parts) {
+				part.WriteString(regexp.QuoteMeta(string(delim)))
+			} else {
+				part.WriteString(`\` + string(delim))
+			}
+		case '\\' == r && "" != rest: /* Other escapes. */
+			r, size := utf8.DecodeRuneInString(rest)
+			rest = rest[size:]
+			part.WriteRune('\\')
+			part.WriteRune(r)
+		default:
+			part.WriteRune(r)
+		}
+	}
+
+	/* Work out the flags. */
+	t := &Transform{expr: expr}
+	var caseless bool
+	for _, f := range rest {
+		switch f {
+		case 'g':
+			t.global = true
+		case 'i':
+			caseless = true
+		default:
+			return nil, fmt.Errorf("unknown flag %q", f)
+		}
+	}
+
+	/* Compile the regex and convert the replacement. */
+	reStr := parts[0]
+	if caseless {
+		reStr = "(?i)" + reStr
+	}
+	var err error
+	if t.re, err = regexp.Compile(reStr); nil != err {
+		return nil, fmt.Errorf("compiling regex: %w", err)
+	}
+	t.repl = convertReplacement(parts[1])
+
+	return t, nil
+}
+
+// convertReplacement converts a sed-style replacement into the form used by
+// regexp.Expand.
+func convertReplacement(repl string) string {
+	var (
+		sb      strings.Builder
+		escaped bool
+	)
+	for _, r := range repl {
+		switch {
+		case escaped && '0' <= r && '9' >= r: /* Submatch. */
+			sb.WriteString("${" + string(r) + "}")
+		case escaped && '$' == r:
+			sb.WriteString("$$")
+		case escaped: /* Anything else is literal. */
+			sb.WriteRune(r)
+		case '\\' == r:
+			escaped = true
+			continue
+		case '&' == r: /* Whole match. */
+			sb.WriteString("${0}")
+		case '$' == r:
+			sb.WriteString("$$")
+		default:
+			sb.WriteRune(r)
+		}
+		escaped = false
+	}
+	if escaped { /* Trailing backslash. */
+		sb.WriteRune('\\')
+	}
+	return sb.String()
+}
+
+// Apply applies t to s.
+func (t *Transform) Apply(s string) string {
+	if t.global {
+		return t.re.ReplaceAllString(s, t.repl)
+	}
+	loc := t.re.FindStringSubmatchIndex(s)
+	if nil == loc {
+		return s
+	}
+	return s[:loc[0]] +
+		string(t.re.ExpandString(nil, t.repl, s, loc)) +
+		s[loc[1]:]
+}
+
+// String returns the expression from which t was parsed.
+func (t *Transform) String() string {
+	return t.expr
+}
+
+// MarshalText implements encoding.TextMarshaler.  It returns the expression
+// from which t was parsed.
+func (t *Transform) MarshalText() ([]byte, error) {
+	return []byte(t.expr), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler by parsing text with
+// ParseTransform.
+func (t *Transform) UnmarshalText(text []byte) error {
+	nt, err := ParseTransform(string(text))
+	if nil != err {
+		return err
+	}
+	*t = *nt
+	return nil
+}
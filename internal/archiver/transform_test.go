@@ -0,0 +1,140 @@
+package archiver
+
+/*
+ * transform_test.go
+ * Tests for transform.go
+ * By J. Stuart McMurray
+ * Created 20261018
+ * Last Modified 20261018
+ */
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTransformApply(t *testing.T) {
+	cs := []struct {
+		expr string
+		have string
+		want string
+	}{
+		{`s/a/b/`, "aaa", "baa"},
+		{`s/a/b/g`, "aaa", "bbb"},
+		{`s/A/b/`, "aaa", "aaa"},
+		{`s/A/b/i`, "aaa", "baa"},
+		{`s/A/b/gi`, "aaa", "bbb"},
+		{`s/x/b/`, "aaa", "aaa"},
+		{`s/^old_pkg\//new_pkg\//`, "old_pkg/a.go", "new_pkg/a.go"},
+		{`s,^old_pkg/,new_pkg/,`, "x/old_pkg/a.go", "x/old_pkg/a.go"},
+		{`s/\(a\)/b/`, "(a)", "b"},
+		{`s|a\|b|c|`, "a|b", "c"},
+		{`s/(a)(b)/\2\1/`, "abab", "baab"},
+		{`s/(a)(b)/\2\1/g`, "abab", "baba"},
+		{`s/b+/[&]/`, "abbc", "a[bb]c"},
+		{`s/b+/[\&]/`, "abbc", "a[&]c"},
+		{`s/b/$1/`, "abc", "a$1c"},
+		{`s/b/\\/`, "abc", `a\c`},
+		{`s/\.go$/.txt/`, "a.go.go", "a.go.txt"},
+		{`s/^/x\//`, "a", "x/a"},
+		{`s/é/e/g`, "éé", "ee"},
+		{`séaébé`, "a", "b"},
+		{`s/a//`, "abc", "bc"},
+	}
+	for _, c := range cs {
+		tr, err := ParseTransform(c.expr)
+		if nil != err {
+			t.Errorf("Error parsing %s: %s", c.expr, err)
+			continue
+		}
+		if got := tr.Apply(c.have); got != c.want {
+			t.Errorf(
+				"Incorrect result\n"+
+					"expr: %s\n"+
+					"have: %s\n"+
+					" got: %s\n"+
+					"want: %s",
+				c.expr,
+				c.have,
+				got,
+				c.want,
+			)
+		}
+		if got := tr.String(); got != c.expr {
+			t.Errorf("String: got %s, want %s", got, c.expr)
+		}
+	}
+}
+
+func TestParseTransform_Errors(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`s`,
+		`x/a/b/`,
+		`s\a\b\`,
+		`s/a/b`,
+		`s/a`,
+		`s/a\/b/`,
+		`s/a/b/x`,
+		`s/(/b/`,
+	} {
+		if _, err := ParseTransform(expr); nil == err {
+			t.Errorf("No error parsing %q", expr)
+		}
+	}
+}
+
+func TestTransform_JSON(t *testing.T) {
+	var trs []*Transform
+	if err := json.Unmarshal(
+		[]byte(`["s/a/b/", "s/c/d/g"]`),
+		&trs,
+	); nil != err {
+		t.Fatalf("Unmarshal error: %s", err)
+	}
+	if 2 != len(trs) {
+		t.Fatalf("Expected 2 transforms, got %d", len(trs))
+	}
+	if got, want := trs[1].Apply("cc"), "dd"; got != want {
+		t.Errorf("Apply: got %s, want %s", got, want)
+	}
+	b, err := json.Marshal(trs)
+	if nil != err {
+		t.Fatalf("Marshal error: %s", err)
+	}
+	if got, want := string(b), `["s/a/b/","s/c/d/g"]`; got != want {
+		t.Errorf("Marshal: got %s, want %s", got, want)
+	}
+	if nil == json.Unmarshal([]byte(`["s/a"]`), &trs) {
+		t.Errorf("No error unmarshalling invalid transform")
+	}
+}
+
+func TestArchiverTransform(t *testing.T) {
+	mustParse := func(expr string) *Transform {
+		tr, err := ParseTransform(expr)
+		if nil != err {
+			t.Fatalf("Error parsing %s: %s", expr, err)
+		}
+		return tr
+	}
+	a := Archiver{Transforms: []*Transform{
+		mustParse(`s/^a/b/`),
+		mustParse(`s/^b/..\/..\/c/`),
+	}}
+	if got, want := a.FromHostPath("a/x"), "c/x"; got != want {
+		t.Errorf("FromHostPath: got %s, want %s", got, want)
+	}
+	if got, want := a.outputName("a"), "c"; got != want {
+		t.Errorf("outputName: got %q, want %q", got, want)
+	}
+	if got, want := (Archiver{Transforms: []*Transform{
+		mustParse(`s/.*//`),
+	}}).outputName("a"), ""; got != want {
+		t.Errorf("Empty outputName: got %q, want %q", got, want)
+	}
+	a.UnsafePaths = true
+	if got, want := a.FromHostPath("a/x"), "../../c/x"; got != want {
+		t.Errorf("Unsafe FromHostPath: got %s, want %s", got, want)
+	}
+}
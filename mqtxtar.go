@@ -6,16 +6,20 @@ package main
  * mqtxtar: Tar-like txtar utility
  * By J. Stuart McMurray
  * Created 20230516
- * Last Modified 20240819
+ * Last Modified 20261018
  */
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"regexp"
 	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/magisterquis/mqtxtar/internal/archiver"
 )
@@ -24,6 +28,16 @@ func main() {
 	var (
 		excludeGlobs []string
 		excludeREs   []*regexp.Regexp
+		includeGlobs []string
+		includeREs   []*regexp.Regexp
+		excludeFiles []string
+		newer        string
+		older        string
+		transforms   []*archiver.Transform
+		addTexts     []string
+		addCmds      []string
+		stripComps   int
+		flattenColl  = archiver.FlattenError
 	)
 	/* Actions, of which only one at a time may be used. */
 	var (
@@ -61,7 +75,23 @@ func main() {
 			"I",
 			"",
 			"Optional `file` containing names of paths to "+
-				"add or extract, one per line",
+				"add or extract, one per line, or - for "+
+				"standard input",
+		)
+		selectFrom = flag.String(
+			"select-from",
+			"",
+			"Optional `archive` whose files' names are used as "+
+				"paths to add or extract, matched exactly when "+
+				"listing or extracting (gunzipped if gzipped, "+
+				"regardless of -z, and subject to the size "+
+				"limits)",
+		)
+		nullPaths = flag.Bool(
+			"null",
+			false,
+			"Separate names in the -I file with NULs instead of "+
+				"newlines, as from find -print0",
 		)
 		unsafePaths = flag.Bool(
 			"P",
@@ -83,10 +113,145 @@ func main() {
 			false,
 			"(De)compress archive using gzip",
 		)
+		stdinName = flag.String(
+			"stdin-name",
+			"",
+			"Add standard input to the archive as `name`, with -c",
+		)
+		renameMap = flag.String(
+			"rename-map",
+			"",
+			"Optional `file` with lines of the form "+
+				"\"archive/name => host/path\" giving names "+
+				"to use instead of the usual ones (still under "+
+				"-prefix)",
+		)
+		prefix = flag.String(
+			"prefix",
+			"",
+			"Prepend `prefix` to every name, with -c (usually "+
+				"ending with a /)",
+		)
+		toStdout = flag.Bool(
+			"O",
+			false,
+			"Extract files' contents to standard output instead "+
+				"of to files, with -x (and a header for each "+
+				"file, with -v)",
+		)
+		flatten = flag.Bool(
+			"j",
+			false,
+			"Remove directories from names when listing or "+
+				"extracting",
+		)
+		maxTotalSize = flag.Int64(
+			"max-total-size",
+			0,
+			fmt.Sprintf(
+				"Refuse archives larger than `bytes` "+
+					"uncompressed (-1 for no limit, "+
+					"default none for uncompressed "+
+					"files and %d for standard input "+
+					"or with -z)",
+				archiver.DefaultStdinMaxTotalSize,
+			),
+		)
+		maxEntrySize = flag.Int64(
+			"max-entry-size",
+			0,
+			fmt.Sprintf(
+				"Refuse archives with files larger than "+
+					"`bytes` (-1 for no limit, default "+
+					"none for uncompressed files and %d "+
+					"for standard input or with -z)",
+				archiver.DefaultStdinMaxEntrySize,
+			),
+		)
+		maxEntries = flag.Int(
+			"max-entries",
+			0,
+			fmt.Sprintf(
+				"Refuse archives with more than `N` files "+
+					"(-1 for no limit, default none for "+
+					"uncompressed files and %d for "+
+					"standard input or with -z)",
+				archiver.DefaultStdinMaxEntries,
+			),
+		)
+		checkCollisions = flag.Bool(
+			"check-collisions",
+			false,
+			"Warn about names which collide on "+
+				"case-insensitive filesystems",
+		)
+		strictCollisions = flag.Bool(
+			"strict-collisions",
+			false,
+			"Like -check-collisions, but refuse to extract "+
+				"colliding names",
+		)
+		skipBadNames = flag.Bool(
+			"skip-bad-names",
+			false,
+			"Skip files with names which can't be stored in a "+
+				"txtar archive instead of failing, with -c",
+		)
+		gitRev = flag.String(
+			"git",
+			"",
+			"Create the archive from the files in the git "+
+				"`commit` instead of the filesystem, with -c",
+		)
+		maxSize = flag.Int64(
+			"max-size",
+			0,
+			"Do not add files larger than `bytes`, with -c",
+		)
+		minSize = flag.Int64(
+			"min-size",
+			0,
+			"Do not add files smaller than `bytes`, with -c",
+		)
+		skipBinary = flag.Bool(
+			"skip-binary",
+			false,
+			"Do not add files which look binary, with -c",
+		)
+		useIgnoreFiles = flag.Bool(
+			"ignore-files",
+			false,
+			"Do not add files matched by globs in "+
+				archiver.IgnoreFileName+" files in their "+
+				"directories, with -c",
+		)
+		useGitignore = flag.Bool(
+			"gitignore",
+			false,
+			"Do not add files ignored by .gitignore files, with -c",
+		)
+		excludeVCS = flag.Bool(
+			"exclude-vcs",
+			false,
+			"Do not add or extract version control files "+
+				"(.git, .hg, .svn, etc.)",
+		)
+		ignoreCase = flag.Bool(
+			"i",
+			false,
+			"Ignore case when matching globs and paths",
+		)
+		anchored = flag.Bool(
+			"anchored",
+			false,
+			"Match -exclude and -include globs without slashes "+
+				"against whole paths, not each path "+
+				"component",
+		)
 	)
 	flag.Func(
 		"exclude",
-		"Do not add or extract files matching the`glob` "+
+		"Do not add or extract files matching the `glob` "+
 			"(may be repeated)",
 		func(s string) error {
 			excludeGlobs = append(excludeGlobs, s)
@@ -106,6 +271,122 @@ func main() {
 			return nil
 		},
 	)
+	flag.Func(
+		"X",
+		"Do not add or extract files matching globs in `file`, "+
+			"one per line (may be repeated)",
+		func(s string) error {
+			excludeFiles = append(excludeFiles, s)
+			return nil
+		},
+	)
+	flag.Func(
+		"newer",
+		"Only add files modified after the `time` (RFC3339 or "+
+			"YYYY-MM-DD[ HH:MM[:SS]]) or the file's "+
+			"modification time, with -c",
+		func(s string) error {
+			newer = s /* Parsed after -C. */
+			return nil
+		},
+	)
+	flag.Func(
+		"older",
+		"Only add files modified before the `time` (RFC3339 or "+
+			"YYYY-MM-DD[ HH:MM[:SS]]) or the file's "+
+			"modification time, with -c",
+		func(s string) error {
+			older = s /* Parsed after -C. */
+			return nil
+		},
+	)
+	flag.Func(
+		"include",
+		"Only add or extract files matching the `glob`, "+
+			"unless excluded (may be repeated)",
+		func(s string) error {
+			includeGlobs = append(includeGlobs, s)
+			return nil
+		},
+	)
+	flag.Func(
+		"include-re",
+		"Only add or extract files matching the `regex`, "+
+			"unless excluded (may be repeated)",
+		func(s string) error {
+			re, err := regexp.Compile(s)
+			if nil != err {
+				return err
+			}
+			includeREs = append(includeREs, re)
+			return nil
+		},
+	)
+	flag.Func(
+		"transform",
+		"Rewrite names with the sed-like `expression` "+
+			"s/regex/replacement/[gi] when adding, listing, or "+
+			"extracting (may be repeated, applied in order)",
+		func(s string) error {
+			t, err := archiver.ParseTransform(s)
+			if nil != err {
+				return err
+			}
+			transforms = append(transforms, t)
+			return nil
+		},
+	)
+	flag.Func(
+		"strip-components",
+		"Remove `N` leading path components from names when "+
+			"listing or extracting",
+		func(s string) error {
+			n, err := strconv.Atoi(s)
+			if nil != err {
+				return err
+			}
+			if 0 > n {
+				return errors.New("must not be negative")
+			}
+			stripComps = n
+			return nil
+		},
+	)
+	flag.Func(
+		"j-collisions",
+		"With -j, the `policy` for files with the same name: "+
+			"error, skip, or suffix (with .N) (default \"error\")",
+		func(s string) (err error) {
+			flattenColl, err = archiver.ParseFlattenPolicy(s)
+			return err
+		},
+	)
+	flag.Func(
+		"add-text",
+		"Add a file with the name and contents given as "+
+			"`name=content`, with -c (may be repeated)",
+		func(s string) error {
+			if !strings.Contains(s, "=") {
+				return errors.New("need name=content")
+			}
+			addTexts = append(addTexts, s)
+			return nil
+		},
+	)
+	flag.Func(
+		"add-cmd",
+		"Add a file with the name and the output of the command "+
+			"given as `name=command [args...]`, split into "+
+			"arguments with shell-like quoting and run without "+
+			"a shell, with -c (may be repeated)",
+		func(s string) error {
+			if !strings.Contains(s, "=") {
+				return errors.New("need name=command")
+			}
+			addCmds = append(addCmds, s)
+			return nil
+		},
+	)
 	flag.Usage = func() {
 		fmt.Fprintf(
 			os.Stderr,
@@ -116,8 +397,12 @@ in txtar format.  For more details on txtar archives, please see
 
 https://pkg.go.dev/golang.org/x/tools/txtar
 
-Paths to be added or extracted can be given as arguments or in a file specified
-with -I or both.  All paths within an archive use forward (Unix) slashes.
+Paths to be added or extracted can be given as arguments, in a file specified
+with -I, as the names of the files in another archive given with -select-from,
+or any combination.  All paths within an archive use forward (Unix) slashes.
+When listing or extracting, paths other than those from -select-from may be
+globs, and a directory selects everything beneath it.  In globs, a ** by itself
+between slashes matches any number of directories.
 
 Options:
 `,
@@ -146,6 +431,70 @@ Options:
 		excludeGlobs,
 		excludeREs,
 	)
+	a.NullPaths = *nullPaths
+	a.StripComponents = stripComps
+	a.Transforms = transforms
+	a.Prefix = *prefix
+	a.StdinName = *stdinName
+	a.AddTexts = addTexts
+	a.AddCmds = addCmds
+	a.Flatten = *flatten
+	a.ToStdout = *toStdout
+	a.FlattenCollisions = flattenColl
+	a.MaxTotalSize = *maxTotalSize
+	a.MaxEntrySize = *maxEntrySize
+	a.MaxEntries = *maxEntries
+	a.CheckCollisions = *checkCollisions
+	a.StrictCollisions = *strictCollisions
+	a.SkipBadNames = *skipBadNames
+	a.Anchored = *anchored
+	a.IgnoreCase = *ignoreCase
+	a.IncludeGlobs = includeGlobs
+	a.IncludeREs = includeREs
+	a.UseIgnoreFiles = *useIgnoreFiles
+	a.UseGitignore = *useGitignore
+	a.GitRev = *gitRev
+	a.MaxSize = *maxSize
+	a.MinSize = *minSize
+	a.SkipBinary = *skipBinary
+	a.ExcludeVCS = *excludeVCS
+	for _, t := range []struct {
+		flag string
+		s    string
+		p    *time.Time
+	}{
+		{"newer", newer, &a.Newer},
+		{"older", older, &a.Older},
+	} {
+		if "" == t.s {
+			continue
+		}
+		var err error
+		if *t.p, err = archiver.ParseTimeOrFile(t.s); nil != err {
+			log.Fatalf("Invalid -%s %q: %s", t.flag, t.s, err)
+		}
+	}
+	for _, fn := range excludeFiles {
+		if err := a.AddExcludesFromFile(fn); nil != err {
+			log.Fatalf("Error adding excludes from %s: %s", fn, err)
+		}
+	}
+	if "" != *renameMap {
+		if err := a.AddRenamesFromFile(*renameMap); nil != err {
+			log.Fatalf(
+				"Error adding renames from %s: %s",
+				*renameMap,
+				err,
+			)
+		}
+	}
+	if "-" == *listFile && (*doCreate && "" != *stdinName ||
+		!*doCreate && "" == *archiveName) {
+		log.Fatalf(
+			"Cannot use -I - when reading the archive " +
+				"or -stdin-name from standard input",
+		)
+	}
 	if "" != *listFile {
 		if err := a.AddPathsFromFile(*listFile); nil != err {
 			log.Fatalf(
@@ -155,6 +504,15 @@ Options:
 			)
 		}
 	}
+	if "" != *selectFrom {
+		if err := a.AddPathsFromArchive(*selectFrom); nil != err {
+			log.Fatalf(
+				"Error adding paths from %s: %s",
+				*selectFrom,
+				err,
+			)
+		}
+	}
 
 	/* Make sure we only have one action. */
 	if 1 != len(slices.DeleteFunc(